@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+)
+
+const (
+	AUDIT_DIR          = "audit"
+	DefaultRetainDays  = 90
+	fileDateFormat     = "2006-01-02"
+	fileSuffix         = ".jsonl"
+	maxQueryPageSize   = 500
+	defaultQueryPageSz = 50
+)
+
+// Entry 一条审计记录
+type Entry struct {
+	Time    time.Time   `json:"time"`             // 操作时间
+	User    string      `json:"user"`             // 操作用户
+	IP      string      `json:"ip"`               // 客户端IP
+	Action  string      `json:"action"`           // 操作类型,如 task.add
+	Target  string      `json:"target,omitempty"` // 操作对象,如任务名、文件路径
+	Detail  interface{} `json:"detail,omitempty"` // 变更摘要
+	Success bool        `json:"success"`          // 是否成功
+	Message string      `json:"message,omitempty"`
+}
+
+// Query 查询条件
+type Query struct {
+	User   string
+	Action string // 前缀匹配,如 task 匹配 task.add/task.delete
+	Target string // 包含匹配
+	Start  time.Time
+	End    time.Time
+	Page   int
+	Size   int
+}
+
+var (
+	mutex      sync.Mutex
+	retainDays = DefaultRetainDays
+)
+
+// dirPath 审计日志目录
+func dirPath() string {
+	return pathutil.GetDataPath(AUDIT_DIR)
+}
+
+// SetRetainDays 设置审计日志保留天数
+func SetRetainDays(days int) {
+	if days <= 0 {
+		return
+	}
+	mutex.Lock()
+	retainDays = days
+	mutex.Unlock()
+}
+
+// GetRetainDays 获取审计日志保留天数
+func GetRetainDays() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return retainDays
+}
+
+// Record 追加一条审计记录,按天写入单独的文件,只追加不修改
+func Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("审计记录序列化失败: %v", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := pathutil.EnsureDir(dirPath()); err != nil {
+		log.Printf("创建审计目录失败: %v", err)
+		return
+	}
+	name := filepath.Join(dirPath(), e.Time.Format(fileDateFormat)+fileSuffix)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("打开审计文件失败: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("写入审计记录失败: %v", err)
+	}
+}
+
+// listFiles 按日期倒序返回审计文件对应的日期
+func listFiles() ([]time.Time, error) {
+	entries, err := os.ReadDir(dirPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var days []time.Time
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		day, err := time.ParseInLocation(fileDateFormat, strings.TrimSuffix(e.Name(), fileSuffix), time.Local)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].After(days[j]) })
+	return days, nil
+}
+
+// match 判断记录是否满足查询条件
+func (q *Query) match(e *Entry) bool {
+	if q.User != "" && e.User != q.User {
+		return false
+	}
+	if q.Action != "" && !strings.HasPrefix(e.Action, q.Action) {
+		return false
+	}
+	if q.Target != "" && !strings.Contains(e.Target, q.Target) {
+		return false
+	}
+	if !q.Start.IsZero() && e.Time.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && e.Time.After(q.End) {
+		return false
+	}
+	return true
+}
+
+// Search 按条件分页查询,结果按时间倒序,返回当前页和总条数
+func Search(q Query) ([]Entry, int, error) {
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.Size <= 0 {
+		q.Size = defaultQueryPageSz
+	}
+	if q.Size > maxQueryPageSize {
+		q.Size = maxQueryPageSize
+	}
+
+	days, err := listFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skip := (q.Page - 1) * q.Size
+	total := 0
+	result := make([]Entry, 0, q.Size)
+	for _, day := range days {
+		// 按文件日期跳过不在时间范围内的文件
+		if !q.Start.IsZero() && day.AddDate(0, 0, 1).Before(q.Start) {
+			continue
+		}
+		if !q.End.IsZero() && day.After(q.End) {
+			continue
+		}
+
+		entries, err := readFile(day)
+		if err != nil {
+			log.Printf("读取审计文件失败[%s]: %v", day.Format(fileDateFormat), err)
+			continue
+		}
+		// 文件内按时间正序,倒序遍历
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !q.match(&entries[i]) {
+				continue
+			}
+			if total >= skip && len(result) < q.Size {
+				result = append(result, entries[i])
+			}
+			total++
+		}
+	}
+	return result, total, nil
+}
+
+// readFile 读取某一天的审计记录
+func readFile(day time.Time) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(dirPath(), day.Format(fileDateFormat)+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Clean 删除超过保留天数的审计文件
+func Clean() error {
+	days, err := listFiles()
+	if err != nil {
+		return fmt.Errorf("读取审计目录失败: %v", err)
+	}
+	cutoff := time.Now().AddDate(0, 0, -GetRetainDays())
+	for _, day := range days {
+		if !day.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+		name := day.Format(fileDateFormat) + fileSuffix
+		if err := os.Remove(filepath.Join(dirPath(), name)); err != nil {
+			log.Printf("删除审计文件失败[%s]: %v", name, err)
+			continue
+		}
+		log.Printf("已清理过期审计日志: %s", name)
+	}
+	return nil
+}
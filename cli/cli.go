@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"xuanwu/config"
+	serve "xuanwu/gin"
+	"xuanwu/lib/pathutil"
+	xwlog "xuanwu/log"
+
+	"github.com/tidwall/gjson"
+)
+
+// 跟踪日志时检查文件变化的间隔
+const followInterval = 500 * time.Millisecond
+
+// Usage 管理命令说明
+const Usage = `管理命令:
+  xuanwu task list|add|rm|enable|disable|run   管理定时任务
+  xuanwu logs [-n 行数] [-f] <任务名称>        查看任务日志
+  xuanwu config validate [配置文件]            校验配置文件
+  xuanwu user reset-password [-username 名称]  重置管理员密码
+  xuanwu user disable-2fa                      关闭两步验证,用于验证设备丢失时
+  xuanwu service install|uninstall|status      管理systemd服务
+
+服务运行时通过数据目录下的 run/admin.sock 操作运行中的服务,未运行时直接操作数据目录
+`
+
+// Run 执行管理命令,返回进程退出码
+func Run(args []string) int {
+	cmd, args := args[0], args[1:]
+	run, ok := map[string]func([]string) int{
+		"task":    runTask,
+		"logs":    runLogs,
+		"config":  runConfig,
+		"user":    runUser,
+		"service": runService,
+	}[cmd]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知命令: %s\n\n%s", cmd, Usage)
+		return 2
+	}
+
+	// 命令行操作同样记录到主日志,轮转由运行中的服务负责
+	raw, _ := os.ReadFile(config.ConfigPath())
+	opts := xwlog.ParseOptions(config.ApplyOverrides(gjson.ParseBytes(raw)))
+	opts.Rotate = xwlog.RotateOptions{}
+	if writer, err := xwlog.InitSystem(opts); err == nil {
+		defer writer.Close()
+	}
+	return run(args)
+}
+
+// fail 输出错误并返回退出码1
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "错误:", err)
+	return 1
+}
+
+// runLogs 查看任务日志
+func runLogs(args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	lines := fs.Int("n", 50, "显示最后的行数,0表示全部")
+	follow := fs.Bool("f", false, "持续输出新增的日志")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	name := fs.Arg(0)
+	if fs.NArg() != 1 || name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		fmt.Fprint(os.Stderr, "用法: xuanwu logs [-n 行数] [-f] <任务名称>\n")
+		return 2
+	}
+
+	// 任务日志是最近几次已结束执行的合并视图
+	f, err := os.Open(pathutil.GetLogPath(name + ".log"))
+	if err != nil && !os.IsNotExist(err) {
+		return fail(err)
+	}
+	runs, listErr := xwlog.ListRuns(name)
+	if listErr != nil {
+		return fail(listErr)
+	}
+	// 跟踪时等待第一次执行
+	if f == nil && len(runs) == 0 && !*follow {
+		return fail(fmt.Errorf("任务[%s]没有日志", name))
+	}
+	if f != nil {
+		_, err = xwlog.TailLines(f, *lines, os.Stdout)
+		f.Close()
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if !*follow {
+		return 0
+	}
+	return followRuns(name, runs)
+}
+
+// followRuns 持续输出任务新的执行日志,已结束的执行已包含在任务日志中,
+// 正在执行的从头输出
+func followRuns(name string, runs []xwlog.RunInfo) int {
+	done := map[string]bool{}
+	for _, run := range runs {
+		if !run.Running {
+			done[run.ID] = true
+		}
+	}
+	offsets := map[string]int64{}
+	for {
+		runs, err := xwlog.ListRuns(name)
+		if err != nil {
+			return fail(err)
+		}
+		// 按时间从旧到新输出
+		for i := len(runs) - 1; i >= 0; i-- {
+			run := runs[i]
+			if done[run.ID] {
+				continue
+			}
+			offset, started := offsets[run.ID]
+			if !started {
+				fmt.Printf("\n%s\n", run.Start.Format("2006-01-02 15:04:05"))
+			}
+			n, err := copyFrom(name, run.ID, offset)
+			if err != nil {
+				return fail(err)
+			}
+			offsets[run.ID] = offset + n
+			if !run.Running {
+				done[run.ID] = true
+				delete(offsets, run.ID)
+			}
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// copyFrom 从指定位置输出执行日志中完整的行,返回读取的字节数
+func copyFrom(name, id string, offset int64) (int64, error) {
+	path, err := xwlog.RunLogPath(name, id)
+	if err != nil {
+		// 执行日志可能已被清理
+		return 0, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return xwlog.DecodeText(f, os.Stdout)
+}
+
+// runConfig 配置文件命令
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" || len(args) > 2 {
+		fmt.Fprint(os.Stderr, "用法: xuanwu config validate [配置文件]\n")
+		return 2
+	}
+	path := config.ConfigPath()
+	if len(args) == 2 {
+		path, _ = filepath.Abs(args[1])
+	}
+
+	version, err := config.ValidateFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", path)
+		return fail(err)
+	}
+	fmt.Printf("%s 校验通过\n", path)
+	if data, err := os.ReadFile(path); err == nil {
+		for _, field := range config.UnknownFields(data) {
+			fmt.Printf("警告: 未知字段 %s,加载时忽略\n", field)
+		}
+	}
+	if version < config.SchemaVersion {
+		fmt.Printf("配置结构版本为%d,启动时将自动升级到%d\n", version, config.SchemaVersion)
+	}
+	return 0
+}
+
+// runUser 用户管理命令
+func runUser(args []string) int {
+	if len(args) == 1 && args[0] == "disable-2fa" {
+		return runDisableTwoFactor()
+	}
+	if len(args) == 0 || args[0] != "reset-password" {
+		fmt.Fprint(os.Stderr, "用法: xuanwu user reset-password [-username 名称]\n      xuanwu user disable-2fa\n")
+		return 2
+	}
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	username := fs.String("username", "", "同时修改用户名")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if !config.IsInstalled() {
+		return fail(fmt.Errorf("程序未安装,请先在面板中完成初始化"))
+	}
+
+	// 从标准输入读取新密码,支持管道输入
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprint(os.Stderr, "新密码: ")
+	password, err := reader.ReadString('\n')
+	if err != nil && password == "" {
+		return fail(fmt.Errorf("读取密码失败: %v", err))
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fail(fmt.Errorf("密码不能为空"))
+	}
+
+	change := config.Change{User: serve.AdminUser, Reason: "命令行重置密码"}
+	if err := serve.ResetPassword(*username, password, change); err != nil {
+		return fail(err)
+	}
+	log.Printf("管理员密码已通过命令行重置")
+	fmt.Println("密码已重置")
+	return 0
+}
+
+// runDisableTwoFactor 关闭两步验证并清除密钥和恢复码
+func runDisableTwoFactor() int {
+	if !config.IsInstalled() {
+		return fail(fmt.Errorf("程序未安装,请先在面板中完成初始化"))
+	}
+	change := config.Change{User: serve.AdminUser, Reason: "命令行关闭两步验证"}
+	if err := serve.DisableTwoFactor(change); err != nil {
+		return fail(err)
+	}
+	fmt.Println("两步验证已关闭")
+	return 0
+}
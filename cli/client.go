@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+	"xuanwu/config"
+	serve "xuanwu/gin"
+	"xuanwu/xuanwu"
+
+	"github.com/tidwall/gjson"
+)
+
+// 连接管理socket的超时时间
+const dialTimeout = time.Second
+
+// client 调用面板接口,服务运行时通过管理socket,未运行时在进程内直接处理
+type client struct {
+	http   *http.Client
+	online bool // 是否连接到运行中的服务
+}
+
+// handlerTransport 将请求交给进程内的handler处理
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &responseRecorder{header: http.Header{}}
+	t.handler.ServeHTTP(rec, req)
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.code, http.StatusText(rec.code)),
+		StatusCode:    rec.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// responseRecorder 在内存中记录进程内handler的响应
+type responseRecorder struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+// Flush 内容已在内存中,gin的 Writer.Flush 需要
+func (r *responseRecorder) Flush() {}
+
+// newClient 优先连接运行中的服务,连接失败时直接操作数据目录
+func newClient() (*client, error) {
+	sock := serve.AdminSocketPath()
+	if conn, err := net.DialTimeout("unix", sock, dialTimeout); err == nil {
+		conn.Close()
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}
+		return &client{http: &http.Client{Transport: transport}, online: true}, nil
+	}
+
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	xuanwu.InitIdleScheduler()
+	handler, err := serve.LocalHandler(config.ApplyOverrides(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return &client{http: &http.Client{Transport: handlerTransport{handler}}}, nil
+}
+
+// call 调用接口,返回data字段和提示信息,code不为0时返回错误
+func (cl *client) call(method, path string, query url.Values, body interface{}) (gjson.Result, string, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return gjson.Result{}, "", err
+		}
+		reader = bytes.NewReader(data)
+	}
+	u := "http://xuanwu" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return gjson.Result{}, "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := cl.http.Do(req)
+	if err != nil {
+		return gjson.Result{}, "", fmt.Errorf("请求服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return gjson.Result{}, "", err
+	}
+	res := gjson.ParseBytes(data)
+	message := res.Get("message").String()
+	if res.Get("code").Int() != 0 {
+		return gjson.Result{}, message, fmt.Errorf("%s", message)
+	}
+	return res.Get("data"), message, nil
+}
+
+// get 调用GET接口
+func (cl *client) get(path string, query url.Values) (gjson.Result, string, error) {
+	return cl.call(http.MethodGet, path, query, nil)
+}
+
+// post 调用POST接口
+func (cl *client) post(path string, body interface{}) (gjson.Result, string, error) {
+	return cl.call(http.MethodPost, path, nil, body)
+}
@@ -0,0 +1,217 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"xuanwu/config"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+// 关闭时除等待任务外,还需要关闭web服务和结束残留进程的时间
+const serviceStopMargin = 30
+
+const serviceUsage = `用法: xuanwu service <命令> [参数]
+
+命令:
+  install [-user] [-name 名称] [-run-as 用户] [-now] [-- 启动参数...]
+                         生成systemd服务并设置开机启动,-now 同时立即启动
+  uninstall [-user] [-name 名称]
+                         停止并删除systemd服务
+  status [-user] [-name 名称]
+                         查看服务状态
+
+-user 安装为当前用户的服务(systemctl --user),默认安装为系统服务
+`
+
+// serviceOptions 服务命令的公共参数
+type serviceOptions struct {
+	user bool
+	name string
+}
+
+// unitPath systemd服务文件路径
+func (o *serviceOptions) unitPath() (string, error) {
+	if !o.user {
+		return filepath.Join("/etc/systemd/system", o.name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", o.name+".service"), nil
+}
+
+// systemctl 执行systemctl命令,输出直接显示
+func (o *serviceOptions) systemctl(args ...string) error {
+	if o.user {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// runService systemd服务管理命令
+func runService(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, serviceUsage)
+		return 2
+	}
+	if runtime.GOOS != "linux" {
+		return fail(fmt.Errorf("systemd服务只支持linux"))
+	}
+
+	cmd, args := args[0], args[1:]
+	opts := &serviceOptions{}
+	fs := flag.NewFlagSet("service "+cmd, flag.ContinueOnError)
+	fs.BoolVar(&opts.user, "user", false, "当前用户的服务")
+	fs.StringVar(&opts.name, "name", "xuanwu", "服务名称")
+	runAs := fs.String("run-as", "", "系统服务的运行用户,默认为root")
+	now := fs.Bool("now", false, "安装后立即启动")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.name == "" || strings.ContainsAny(opts.name, `/\ `) {
+		return fail(fmt.Errorf("服务名称错误: %s", opts.name))
+	}
+
+	switch cmd {
+	case "install":
+		return serviceInstall(opts, *runAs, *now, fs.Args())
+	case "uninstall":
+		return serviceUninstall(opts)
+	case "status":
+		if err := opts.systemctl("status", "--no-pager", opts.name); err != nil {
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: service %s\n\n%s", cmd, serviceUsage)
+	return 2
+}
+
+// serviceInstall 生成服务文件并设置开机启动
+func serviceInstall(opts *serviceOptions, runAs string, now bool, extra []string) int {
+	if opts.user && runAs != "" {
+		return fail(fmt.Errorf("用户服务不支持 -run-as"))
+	}
+	path, err := opts.unitPath()
+	if err != nil {
+		return fail(err)
+	}
+	if err := pathutil.EnsureDir(filepath.Dir(path)); err != nil {
+		return fail(err)
+	}
+	// 服务的工作目录必须存在
+	if err := pathutil.EnsureDir(pathutil.GetDataDir()); err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(path, []byte(buildUnit(opts.user, runAs, extra)), 0644); err != nil {
+		return fail(fmt.Errorf("写入服务文件失败: %v", err))
+	}
+	fmt.Println("已生成服务文件:", path)
+
+	if err := opts.systemctl("daemon-reload"); err != nil {
+		return fail(fmt.Errorf("systemctl daemon-reload 失败: %v", err))
+	}
+	enable := []string{"enable", opts.name}
+	if now {
+		enable = []string{"enable", "--now", opts.name}
+	}
+	if err := opts.systemctl(enable...); err != nil {
+		return fail(fmt.Errorf("启用服务失败: %v", err))
+	}
+	if opts.user {
+		fmt.Println("用户服务在用户登出后会停止,需要常驻时执行: loginctl enable-linger " + os.Getenv("USER"))
+	}
+	return 0
+}
+
+// serviceUninstall 停止服务并删除服务文件
+func serviceUninstall(opts *serviceOptions) int {
+	path, err := opts.unitPath()
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fail(fmt.Errorf("服务文件不存在: %s", path))
+	}
+	if err := opts.systemctl("disable", "--now", opts.name); err != nil {
+		fmt.Fprintln(os.Stderr, "停止服务失败:", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fail(err)
+	}
+	if err := opts.systemctl("daemon-reload"); err != nil {
+		return fail(fmt.Errorf("systemctl daemon-reload 失败: %v", err))
+	}
+	fmt.Println("已删除服务文件:", path)
+	return 0
+}
+
+// buildUnit 生成服务文件,使用当前程序路径、数据目录和配置文件路径
+func buildUnit(user bool, runAs string, extra []string) string {
+	args := []string{pathutil.GetExecutablePath(), "-data-dir", pathutil.GetDataDir()}
+	if path := config.ConfigPath(); path != pathutil.GetDataPath("config.json") {
+		args = append(args, "-config", path)
+	}
+	args = append(args, extra...)
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, quoteUnitArg(a))
+	}
+
+	// 停止超时需要大于等待任务结束的时间
+	raw, _ := os.ReadFile(config.ConfigPath())
+	grace := gjson.GetBytes(raw, "shutdown_grace_seconds").Int()
+	if grace <= 0 {
+		grace = 30
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=xuanwu 定时任务管理面板\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=notify\n")
+	b.WriteString("NotifyAccess=main\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", strings.ReplaceAll(pathutil.GetDataDir(), "%", "%%"))
+	if runAs != "" {
+		fmt.Fprintf(&b, "User=%s\n", runAs)
+	}
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5\n")
+	b.WriteString("WatchdogSec=60\n")
+	// 只向主进程发送停止信号,由程序等待任务结束,超时后再结束所有进程
+	b.WriteString("KillMode=mixed\n")
+	fmt.Fprintf(&b, "TimeoutStopSec=%d\n\n", grace+serviceStopMargin)
+	b.WriteString("[Install]\n")
+	if user {
+		b.WriteString("WantedBy=default.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	return b.String()
+}
+
+// quoteUnitArg 转义服务文件中的参数,%需要写成%%
+func quoteUnitArg(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
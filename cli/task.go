@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// stringList 可重复指定的命令行参数
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+const taskUsage = `用法: xuanwu task <命令> [参数]
+
+命令:
+  list                         列出所有任务
+  add -name 名称 -times 表达式 -exec 命令 [-times 表达式...] [-workdir 目录] [-disable]
+                               添加或更新任务
+  rm <名称>                    删除任务
+  enable <名称>                启用任务
+  disable <名称>               禁用任务
+  run <名称>                   立即执行任务并输出结果
+`
+
+// runTask 任务管理命令
+func runTask(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, taskUsage)
+		return 2
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list", "ls":
+		return taskList()
+	case "add":
+		return taskAdd(args)
+	case "rm", "delete":
+		return taskByName(args, func(cl *client, name string) (string, error) {
+			_, msg, err := cl.get("/api/cron/delete", url.Values{"name": {name}})
+			return msg, err
+		})
+	case "enable":
+		return taskByName(args, func(cl *client, name string) (string, error) {
+			_, msg, err := cl.get("/api/cron/enable", url.Values{"name": {name}})
+			return msg, err
+		})
+	case "disable":
+		return taskByName(args, func(cl *client, name string) (string, error) {
+			_, msg, err := cl.get("/api/cron/disable", url.Values{"name": {name}})
+			return msg, err
+		})
+	case "run":
+		return taskRun(args)
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: task %s\n\n%s", cmd, taskUsage)
+	return 2
+}
+
+// taskList 列出任务
+func taskList() int {
+	cl, err := newClient()
+	if err != nil {
+		return fail(err)
+	}
+	data, _, err := cl.get("/api/cron/list", nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "名称\t启用\t状态\t下次执行\t上次结果\t定时\t命令")
+	for _, t := range data.Array() {
+		var times []string
+		for _, v := range t.Get("times").Array() {
+			times = append(times, v.String())
+		}
+		enable := "否"
+		if t.Get("enable").Bool() {
+			enable = "是"
+		}
+		next := t.Get("next").String()
+		if next == "" {
+			next = "-"
+		}
+		last := "-"
+		if run := t.Get("last_run"); run.Get("running").Bool() {
+			last = "执行中"
+		} else if run.Get("status").Exists() {
+			last = run.Get("status").String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.Get("name").String(), enable, t.Get("status").String(), next, last,
+			strings.Join(times, ","), t.Get("exec").String())
+	}
+	w.Flush()
+	if !cl.online {
+		fmt.Fprintln(os.Stderr, "(服务未运行,显示配置文件中的任务)")
+	}
+	return 0
+}
+
+// taskAdd 添加或更新任务
+func taskAdd(args []string) int {
+	fs := flag.NewFlagSet("task add", flag.ContinueOnError)
+	name := fs.String("name", "", "任务名称")
+	var times stringList
+	fs.Var(&times, "times", "定时表达式,可重复指定")
+	exec := fs.String("exec", "", "执行命令")
+	workdir := fs.String("workdir", "", "工作目录,默认为数据目录")
+	disable := fs.Bool("disable", false, "添加后不启用")
+	ansi := fs.String("ansi", "", "转义序列的处理方式 strip preserve html,默认strip")
+	color := fs.String("color", "", "true时要求任务输出颜色,false时禁止")
+	encoding := fs.String("encoding", "", "输出编码 utf-8 gbk gb18030 big5 utf-16 utf-16le utf-16be auto,默认Windows为gbk,其他系统为utf-8")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" || len(times) == 0 || *exec == "" {
+		fmt.Fprint(os.Stderr, "任务名称、定时表达式和执行命令不能为空\n\n")
+		fs.Usage()
+		return 2
+	}
+
+	cl, err := newClient()
+	if err != nil {
+		return fail(err)
+	}
+	task := map[string]interface{}{
+		"name":    *name,
+		"times":   []string(times),
+		"workdir": *workdir,
+		"exec":    *exec,
+		"enable":  !*disable,
+	}
+	// 未指定时保留任务原有的配置
+	if *encoding != "" {
+		task["encoding"] = *encoding
+	}
+	if *ansi != "" {
+		task["ansi"] = *ansi
+	}
+	if *color != "" {
+		enable, err := strconv.ParseBool(*color)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "color只能为 true 或 false")
+			return 2
+		}
+		task["color"] = enable
+	}
+	_, msg, err := cl.post("/api/cron/add", task)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Println(msg)
+	return 0
+}
+
+// taskByName 执行只需要任务名称的操作
+func taskByName(args []string, fn func(cl *client, name string) (string, error)) int {
+	if len(args) != 1 || args[0] == "" {
+		fmt.Fprint(os.Stderr, taskUsage)
+		return 2
+	}
+	cl, err := newClient()
+	if err != nil {
+		return fail(err)
+	}
+	msg, err := fn(cl, args[0])
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Println(msg)
+	return 0
+}
+
+// taskRun 立即执行任务,输出执行结果,执行失败时返回1
+func taskRun(args []string) int {
+	if len(args) != 1 || args[0] == "" {
+		fmt.Fprint(os.Stderr, taskUsage)
+		return 2
+	}
+	cl, err := newClient()
+	if err != nil {
+		return fail(err)
+	}
+	data, _, err := cl.post("/api/cron/execute", map[string]string{"name": args[0]})
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Print(data.Get("output").String())
+	msg := data.Get("message").String()
+	fmt.Fprintln(os.Stderr, msg)
+	if data.Get("status").String() == "failure" {
+		return 1
+	}
+	return 0
+}
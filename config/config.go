@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sync"
+	"time"
 	"xuanwu/lib/pathutil"
 
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 var (
@@ -17,37 +21,131 @@ var (
 	Version   = "1.0.0"
 )
 
+// 未安装时使用的默认配置,不包含账号密码,需要通过安装接口设置
+const defaultConfig = `{
+	"name": "xuanwu",
+	"cookie_expire_days": 30,
+	"log_clean_days": 7,
+	"task": []
+}`
+
+// 配置文件修改锁,保证读取-修改-写入过程不会互相覆盖
+var configLock sync.Mutex
+
+// 通过命令行或环境变量指定的配置文件路径
+var configPath string
+
+// SetConfigPath 指定配置文件路径,相对路径基于当前工作目录
+func SetConfigPath(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	configPath = abs
+	return nil
+}
+
+// ConfigPath 配置文件路径,未指定时为数据目录下的config.json
+func ConfigPath() string {
+	if configPath != "" {
+		return configPath
+	}
+	return pathutil.GetDataPath("config.json")
+}
+
+// backupPath 最近一次成功写入的配置备份
+func backupPath(filePath string) string {
+	return filePath + ".bak"
+}
+
+// IsInstalled 配置文件存在即视为已安装
+func IsInstalled() bool {
+	return pathutil.IsFileExist(ConfigPath())
+}
+
+// DefaultConfig 获取默认配置
+func DefaultConfig() gjson.Result {
+	raw, _ := sjson.Set(defaultConfig, "schema_version", SchemaVersion)
+	return gjson.Parse(raw)
+}
+
 // 将config文件读取到json字符串
 func ReadConfigFileToJson() (gjson.Result, error) {
-	configPath := pathutil.GetDataPath("config.json")
+	configPath := ConfigPath()
 	jsonByte, err := os.ReadFile(configPath)
 	if err != nil {
-		fmt.Println("配置文件读取失败")
-		/* 配置文件不存在,创建json文件 */
-		str := fmt.Sprintf(`{
-			"name": "xuanwu",
-			"username":"admin",
-			"password":"8c6976e5b5410415bde908bd4dee15dfb167a9c873fc4bb8a81f6f2ab448a918",
-			"cookie_expire_days": 30,
-			"log_clean_days": 7,
-			"task": []
-		  }`)
-		err := WriteConfigFile(configPath, []byte(str))
-		if err != nil {
-			log.Println("配置文件创建失败")
-			return gjson.Parse(""), err
+		if os.IsNotExist(err) {
+			/* 配置文件不存在,返回默认配置,等待安装 */
+			return DefaultConfig(), nil
 		}
-		log.Println("配置文件创建成功")
-		return gjson.Parse(str), nil
+		fmt.Println("配置文件读取失败")
+		return gjson.Parse(""), err
 	}
 
-	return gjson.Parse(string(jsonByte)), nil
+	// 运行中手动修改出错时只返回错误,不覆盖正在编辑的文件,备份恢复只在启动时进行
+	if !gjson.ValidBytes(jsonByte) {
+		return gjson.Parse(""), fmt.Errorf("配置文件格式错误")
+	}
+	return gjson.ParseBytes(jsonByte), nil
 }
 
-// 写入json到config文件
-func WriteConfigFile(filePath string, data []byte) error {
-	if err := pathutil.EnsureFile(filePath); err != nil {
-		fmt.Println("config文件创建失败")
+// RecoverConfig 启动时检查配置文件,格式错误时从备份恢复,损坏的文件改名保留
+func RecoverConfig() error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	configPath := ConfigPath()
+	data, err := os.ReadFile(configPath)
+	if err != nil || gjson.ValidBytes(data) {
+		// 文件不存在时等待安装,其他读取错误由之后的读取报告
+		return nil
+	}
+	backup, err := os.ReadFile(backupPath(configPath))
+	if err != nil || !gjson.ValidBytes(backup) {
+		log.Println("配置文件格式错误,且没有可用的备份")
+		return fmt.Errorf("配置文件格式错误")
+	}
+	// 保留损坏的文件便于排查
+	broken := fmt.Sprintf("%s.broken-%s", configPath, time.Now().Format("20060102150405"))
+	if err := os.Rename(configPath, broken); err != nil {
+		log.Printf("备份损坏的配置文件失败: %v", err)
+	}
+	if err := pathutil.WriteFileAtomic(configPath, backup, 0600); err != nil {
+		log.Printf("从备份恢复配置文件失败: %v", err)
+		return err
+	}
+	log.Printf("配置文件格式错误,已从备份恢复,损坏的文件保存为: %s", broken)
+	return nil
+}
+
+// 写入json到config文件,change 记录到配置历史
+func WriteConfigFile(filePath string, data []byte, change Change) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	return writeConfigFile(filePath, data, change)
+}
+
+// UpdateConfig 在锁内读取最新配置并写入修改后的内容,fn返回错误时不写入
+func UpdateConfig(change Change, fn func(cfg gjson.Result) (string, error)) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	cfg, err := ReadConfigFileToJson()
+	if err != nil {
+		return err
+	}
+	data, err := fn(cfg)
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(ConfigPath(), []byte(data), change)
+}
+
+// writeConfigFile 校验并格式化后原子写入,并保存一份备份,调用方需持有锁
+func writeConfigFile(filePath string, data []byte, change Change) error {
+	// 不符合配置结构的内容不写入
+	if err := ValidateConfig(data); err != nil {
+		log.Printf("拒绝写入配置: %v", err)
 		return err
 	}
 
@@ -58,18 +156,15 @@ func WriteConfigFile(filePath string, data []byte) error {
 		return err
 	}
 
-	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		fmt.Println("config文件打开失败")
-		return err
-	}
-	defer f.Close()
-
-	_, err = f.Write(prettyJSON.Bytes())
-	if err != nil {
+	if err := pathutil.WriteFileAtomic(filePath, prettyJSON.Bytes(), 0600); err != nil {
 		fmt.Println("config文件写入失败")
 		return err
 	}
 
+	// 写入成功后更新备份,作为最近一次正确的配置
+	if err := pathutil.WriteFileAtomic(backupPath(filePath), prettyJSON.Bytes(), 0600); err != nil {
+		log.Printf("配置备份写入失败: %v", err)
+	}
+	saveSnapshot(prettyJSON.Bytes(), change)
 	return nil
 }
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	HISTORY_DIR         = "history"
+	DefaultHistoryLimit = 50 // 默认保留的配置版本数量
+	historyTimeFormat   = "20060102150405.000"
+)
+
+// Change 配置修改的来源,记录到历史版本中
+type Change struct {
+	User   string `json:"user"`   // 操作用户
+	Reason string `json:"reason"` // 修改原因
+}
+
+// Snapshot 配置历史版本
+type Snapshot struct {
+	ID     string          `json:"id"`
+	Time   time.Time       `json:"time"`
+	User   string          `json:"user"`
+	Reason string          `json:"reason"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// DiffItem 两个版本之间的一处差异
+type DiffItem struct {
+	Path string      `json:"path"`          // 字段路径
+	Op   string      `json:"op"`            // added/removed/changed
+	Old  interface{} `json:"old,omitempty"` // 旧值
+	New  interface{} `json:"new,omitempty"` // 新值
+}
+
+// 回滚时保留当前值的字段,避免回滚恢复旧密码或两步验证密钥
+var rollbackKeepFields = []string{"username", "password", "totp"}
+
+// 对比结果中隐藏取值的字段
+var diffMaskPrefixes = []string{"password", "totp."}
+
+var historySeq uint32
+
+// historyDir 历史版本目录
+func historyDir() string {
+	return pathutil.GetDataPath(HISTORY_DIR)
+}
+
+// historyLimit 保留的历史版本数量
+func historyLimit(data []byte) int {
+	if n := gjson.GetBytes(data, "history_limit").Int(); n > 0 {
+		return int(n)
+	}
+	return DefaultHistoryLimit
+}
+
+// saveSnapshot 保存一个历史版本并清理超出数量的旧版本,调用方需持有锁
+func saveSnapshot(data []byte, change Change) {
+	now := time.Now()
+	id := fmt.Sprintf("%s-%03d", now.Format(historyTimeFormat), atomic.AddUint32(&historySeq, 1)%1000)
+	snap := Snapshot{
+		ID:     id,
+		Time:   now,
+		User:   change.User,
+		Reason: change.Reason,
+		Config: json.RawMessage(data),
+	}
+	content, err := json.Marshal(snap)
+	if err != nil {
+		log.Printf("配置历史版本序列化失败: %v", err)
+		return
+	}
+	if err := pathutil.WriteFileAtomic(filepath.Join(historyDir(), id+".json"), content, 0600); err != nil {
+		log.Printf("配置历史版本保存失败: %v", err)
+		return
+	}
+
+	ids, err := historyIDs()
+	if err != nil {
+		return
+	}
+	for _, old := range ids[min(len(ids), historyLimit(data)):] {
+		os.Remove(filepath.Join(historyDir(), old+".json"))
+	}
+}
+
+// historyIDs 按时间倒序返回所有历史版本ID
+func historyIDs() ([]string, error) {
+	entries, err := os.ReadDir(historyDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
+
+// ListSnapshots 获取历史版本列表,不包含配置内容
+func ListSnapshots() ([]Snapshot, error) {
+	ids, err := historyIDs()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Snapshot, 0, len(ids))
+	for _, id := range ids {
+		snap, err := GetSnapshot(id)
+		if err != nil {
+			continue
+		}
+		snap.Config = nil
+		list = append(list, *snap)
+	}
+	return list, nil
+}
+
+// GetSnapshot 读取指定历史版本
+func GetSnapshot(id string) (*Snapshot, error) {
+	// 版本ID只能是文件名,防止读取历史目录以外的文件
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return nil, fmt.Errorf("版本不存在: %s", id)
+	}
+	content, err := os.ReadFile(filepath.Join(historyDir(), id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("版本不存在: %s", id)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(content, &snap); err != nil {
+		return nil, fmt.Errorf("版本文件损坏: %s", id)
+	}
+	return &snap, nil
+}
+
+// flatten 将json展开为 路径->值
+func flatten(prefix string, value gjson.Result, out map[string]gjson.Result) {
+	if value.IsObject() || value.IsArray() {
+		empty := true
+		value.ForEach(func(key, v gjson.Result) bool {
+			empty = false
+			path := key.String()
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			flatten(path, v, out)
+			return true
+		})
+		if !empty {
+			return
+		}
+	}
+	out[prefix] = value
+}
+
+// maskDiffValue 敏感字段只显示是否变化
+func maskDiffValue(path string, v gjson.Result) interface{} {
+	if !v.Exists() {
+		return nil
+	}
+	for _, p := range diffMaskPrefixes {
+		if path == p || strings.HasPrefix(path, p) {
+			return "***"
+		}
+	}
+	return v.Value()
+}
+
+// Diff 对比两个配置,返回按路径排序的差异
+func Diff(oldRaw, newRaw string) []DiffItem {
+	oldMap := map[string]gjson.Result{}
+	newMap := map[string]gjson.Result{}
+	flatten("", gjson.Parse(oldRaw), oldMap)
+	flatten("", gjson.Parse(newRaw), newMap)
+
+	var items []DiffItem
+	for path, ov := range oldMap {
+		nv, ok := newMap[path]
+		switch {
+		case !ok:
+			items = append(items, DiffItem{Path: path, Op: "removed", Old: maskDiffValue(path, ov)})
+		case ov.Raw != nv.Raw:
+			items = append(items, DiffItem{Path: path, Op: "changed", Old: maskDiffValue(path, ov), New: maskDiffValue(path, nv)})
+		}
+	}
+	for path, nv := range newMap {
+		if _, ok := oldMap[path]; !ok {
+			items = append(items, DiffItem{Path: path, Op: "added", New: maskDiffValue(path, nv)})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return items
+}
+
+// Rollback 将配置恢复到指定历史版本,账号密码和两步验证保持当前值
+func Rollback(id string, change Change) error {
+	snap, err := GetSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	return UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		raw := string(snap.Config)
+		var err error
+		for _, key := range rollbackKeepFields {
+			if v := cfg.Get(key); v.Exists() {
+				raw, err = sjson.SetRaw(raw, key, v.Raw)
+			} else {
+				raw, err = sjson.Delete(raw, key)
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		return raw, nil
+	})
+}
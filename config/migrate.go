@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// migrations 配置结构升级函数,key为升级前的版本,每个函数只升级一个版本
+var migrations = map[int]func(raw string) (string, error){
+	1: migrateV1,
+}
+
+// schemaVersionOf 获取配置结构版本,没有该字段的是最早的版本1
+func schemaVersionOf(cfg gjson.Result) int {
+	v := cfg.Get("schema_version")
+	if !v.Exists() {
+		return 1
+	}
+	return int(v.Int())
+}
+
+// Migrate 配置结构版本低于当前版本时依次升级,升级前保留原文件,返回是否执行了升级
+func Migrate() (bool, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	if !IsInstalled() {
+		return false, nil
+	}
+	cfg, err := ReadConfigFileToJson()
+	if err != nil {
+		return false, err
+	}
+	from := schemaVersionOf(cfg)
+	if from >= SchemaVersion {
+		return false, nil
+	}
+
+	raw, err := migrateRaw(cfg.Raw, from)
+	if err != nil {
+		return false, err
+	}
+
+	// 保留升级前的配置,便于回退
+	backup := fmt.Sprintf("%s.v%d", ConfigPath(), from)
+	if err := pathutil.WriteFileAtomic(backup, []byte(cfg.Raw), 0600); err != nil {
+		return false, fmt.Errorf("备份升级前的配置失败: %v", err)
+	}
+	if err := writeConfigFile(ConfigPath(), []byte(raw), Change{User: "system", Reason: fmt.Sprintf("配置从版本%d升级到%d", from, SchemaVersion)}); err != nil {
+		return false, err
+	}
+	log.Printf("配置文件已从版本%d升级到%d,原文件保存为: %s", from, SchemaVersion, backup)
+	return true, nil
+}
+
+// migrateRaw 在内存中将配置从指定版本升级到当前版本
+func migrateRaw(raw string, from int) (string, error) {
+	var err error
+	for v := from; v < SchemaVersion; v++ {
+		fn, ok := migrations[v]
+		if !ok {
+			return "", fmt.Errorf("缺少配置版本%d的升级方法", v)
+		}
+		if raw, err = fn(raw); err != nil {
+			return "", fmt.Errorf("配置从版本%d升级失败: %v", v, err)
+		}
+		if raw, err = sjson.Set(raw, "schema_version", v+1); err != nil {
+			return "", err
+		}
+	}
+	return raw, nil
+}
+
+// ValidateFile 校验配置文件,不修改文件;旧版本结构先在内存中升级后再校验,返回文件的结构版本
+func ValidateFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if !gjson.ValidBytes(data) {
+		_, err := ParseSchema(data)
+		return 0, err
+	}
+	from := schemaVersionOf(gjson.ParseBytes(data))
+	if from < SchemaVersion {
+		raw, err := migrateRaw(string(data), from)
+		if err != nil {
+			return from, err
+		}
+		data = []byte(raw)
+	}
+	return from, ValidateConfig(data)
+}
+
+// migrateV1 统一早期手动编辑配置中常见的类型写法
+// port 数字转字符串, enable "true"/"false" 转布尔值, times 单个字符串转数组, 天数字符串转数字
+func migrateV1(raw string) (string, error) {
+	cfg := gjson.Parse(raw)
+	var err error
+	set := func(path string, value interface{}) {
+		if err == nil {
+			raw, err = sjson.Set(raw, path, value)
+		}
+	}
+
+	if port := cfg.Get("port"); port.Type == gjson.Number {
+		set("port", port.Raw)
+	}
+	for _, key := range []string{"cookie_expire_days", "log_clean_days"} {
+		if v := cfg.Get(key); v.Type == gjson.String {
+			n, convErr := strconv.Atoi(strings.TrimSpace(v.String()))
+			if convErr != nil {
+				return "", fmt.Errorf("%s: 无法转换为数字: %s", key, v.String())
+			}
+			set(key, n)
+		}
+	}
+	if !cfg.Get("task").Exists() {
+		set("task", []interface{}{})
+	}
+
+	for i, task := range cfg.Get("task").Array() {
+		if e := task.Get("enable"); e.Type == gjson.String || e.Type == gjson.Number {
+			set(fmt.Sprintf("task.%d.enable", i), e.Bool())
+		} else if !e.Exists() {
+			set(fmt.Sprintf("task.%d.enable", i), false)
+		}
+		if t := task.Get("times"); t.Type == gjson.String {
+			set(fmt.Sprintf("task.%d.times", i), []string{t.String()})
+		}
+	}
+	return raw, err
+}
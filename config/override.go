@@ -0,0 +1,50 @@
+package config
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 命令行参数和环境变量覆盖的配置项,只在运行时生效,不会写入配置文件
+var (
+	overrides     = map[string]interface{}{}
+	overridesLock sync.RWMutex
+)
+
+// SetOverride 设置运行时覆盖的配置项,优先级高于配置文件
+func SetOverride(key string, value interface{}) {
+	overridesLock.Lock()
+	defer overridesLock.Unlock()
+	overrides[key] = value
+}
+
+// Overrides 返回被覆盖的配置项名称
+func Overrides() []string {
+	overridesLock.RLock()
+	defer overridesLock.RUnlock()
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ApplyOverrides 返回应用了覆盖项的配置,原配置不变
+func ApplyOverrides(cfg gjson.Result) gjson.Result {
+	overridesLock.RLock()
+	defer overridesLock.RUnlock()
+	raw := cfg.Raw
+	if raw == "" {
+		raw = "{}"
+	}
+	for k, v := range overrides {
+		if s, err := sjson.Set(raw, k, v); err == nil {
+			raw = s
+		}
+	}
+	return gjson.Parse(raw)
+}
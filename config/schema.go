@@ -0,0 +1,500 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/tidwall/gjson"
+)
+
+// SchemaVersion 当前配置文件结构版本,结构变化时递增并在 migrations 中添加升级函数
+const SchemaVersion = 2
+
+// CronParser 定时表达式解析器,支持秒级和 @every 等描述符
+var CronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Task 任务配置
+type Task struct {
+	Name     string       `json:"name"`               // 任务名称
+	Times    []string     `json:"times"`              // 定时表达式
+	WorkDir  string       `json:"workdir"`            // 工作目录
+	Exec     string       `json:"exec"`               // 执行命令
+	Enable   bool         `json:"enable"`             // 是否启用
+	Result   *TaskResult  `json:"result,omitempty"`   // 执行结果判定规则
+	Output   *OutputLimit `json:"output,omitempty"`   // 输出限制,未配置的项使用 task_log.output
+	Encoding string       `json:"encoding,omitempty"` // 输出编码,未配置时Windows为gbk,其他系统为utf-8
+	Mask     *MaskConfig  `json:"mask,omitempty"`     // 输出中需要隐藏的内容,与全局的 mask 合并
+	Ansi     string       `json:"ansi,omitempty"`     // 终端转义序列的处理方式 strip/preserve/html,默认strip
+	Color    *bool        `json:"color,omitempty"`    // true时设置TERM和FORCE_COLOR等环境变量要求输出颜色,false时设置NO_COLOR
+}
+
+// 任务输出中终端转义序列的处理方式
+const (
+	AnsiStrip    = "strip"    // 去掉所有转义序列
+	AnsiPreserve = "preserve" // 原样记录
+	AnsiHTML     = "html"     // 只保留颜色和样式,接口可以转换为带颜色的HTML
+)
+
+// 任务输出编码
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingGBK     = "gbk"
+	EncodingGB18030 = "gb18030"
+	EncodingBig5    = "big5"
+	EncodingUTF16   = "utf-16" // 根据BOM判断字节序,没有BOM时为小端
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingAuto    = "auto" // 根据BOM和内容自动检测,不是UTF-8的行按GB18030处理
+)
+
+// TaskEncodings 支持的任务输出编码
+var TaskEncodings = []string{EncodingUTF8, EncodingGBK, EncodingGB18030, EncodingBig5, EncodingUTF16, EncodingUTF16LE, EncodingUTF16BE, EncodingAuto}
+
+// ValidEncoding 编码名称是否支持,不区分大小写
+func ValidEncoding(name string) bool {
+	for _, enc := range TaskEncodings {
+		if strings.EqualFold(name, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskResult 任务执行结果的判定规则。先按退出码判定,未配置 success_codes 时只有0为成功,
+// 不在成功和警告中的退出码为失败;然后输出匹配 failure_patterns 的判定为失败,匹配 warning_patterns 的成功判定为警告
+type TaskResult struct {
+	SuccessCodes    []int    `json:"success_codes,omitempty"`    // 成功的退出码
+	WarningCodes    []int    `json:"warning_codes,omitempty"`    // 警告的退出码
+	FailurePatterns []string `json:"failure_patterns,omitempty"` // 输出匹配时判定为失败的正则表达式
+	WarningPatterns []string `json:"warning_patterns,omitempty"` // 输出匹配时判定为警告的正则表达式
+}
+
+// 输出超过大小限制时的处理方式
+const (
+	OutputLimitContinue = "continue" // 继续执行,只保留开头和结尾的输出
+	OutputLimitKill     = "kill"     // 结束任务进程
+)
+
+// OutputLimit 任务输出的限制,0表示不限制
+type OutputLimit struct {
+	MaxKB        *int   `json:"max_kb,omitempty"`         // 每次执行保留的输出大小
+	MaxLineBytes *int   `json:"max_line_bytes,omitempty"` // 单行的最大长度,超过的部分截断
+	OnLimit      string `json:"on_limit,omitempty"`       // 超过大小限制时的处理方式 continue/kill
+}
+
+// ParseOutputLimit 读取输出限制配置,未配置时返回nil
+func ParseOutputLimit(value gjson.Result) *OutputLimit {
+	if !value.IsObject() {
+		return nil
+	}
+	var limit OutputLimit
+	if err := json.Unmarshal([]byte(value.Raw), &limit); err != nil {
+		return nil
+	}
+	return &limit
+}
+
+// validate 校验输出限制,错误信息以prefix开头
+func (o *OutputLimit) validate(prefix string, add func(format string, args ...interface{})) {
+	if o.MaxKB != nil && *o.MaxKB < 0 {
+		add("%s.max_kb: 不能为负数", prefix)
+	}
+	if o.MaxLineBytes != nil && *o.MaxLineBytes < 0 {
+		add("%s.max_line_bytes: 不能为负数", prefix)
+	}
+	if o.OnLimit != "" && o.OnLimit != OutputLimitContinue && o.OnLimit != OutputLimitKill {
+		add("%s.on_limit: 只能为 continue 或 kill", prefix)
+	}
+}
+
+// MaskConfig 任务输出中敏感内容的隐藏规则,写入执行日志、实时输出和接口返回前替换
+type MaskConfig struct {
+	Enable      *bool    `json:"enable,omitempty"`      // 是否启用,默认启用
+	Builtin     *bool    `json:"builtin,omitempty"`     // 是否使用内置规则(Bearer令牌、Cookie、URL中的密码、password=等),默认使用
+	Values      []string `json:"values,omitempty"`      // 需要隐藏的固定值
+	Env         []string `json:"env,omitempty"`         // 值需要隐藏的环境变量名,支持*通配,未配置时为名称包含TOKEN、SECRET、PASSWORD等的变量
+	Patterns    []string `json:"patterns,omitempty"`    // 正则表达式,有分组时只隐藏分组的内容
+	Replacement string   `json:"replacement,omitempty"` // 替换的文本,默认为 ******
+}
+
+// ParseMaskConfig 读取隐藏规则配置,未配置时返回nil
+func ParseMaskConfig(value gjson.Result) *MaskConfig {
+	if !value.IsObject() {
+		return nil
+	}
+	var mask MaskConfig
+	if err := json.Unmarshal([]byte(value.Raw), &mask); err != nil {
+		return nil
+	}
+	return &mask
+}
+
+// validate 校验隐藏规则,错误信息以prefix开头
+func (m *MaskConfig) validate(prefix string, add func(format string, args ...interface{})) {
+	for i, value := range m.Values {
+		if value == "" {
+			add("%s.values[%d]: 不能为空", prefix, i)
+		}
+	}
+	for i, pattern := range m.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			add("%s.patterns[%d]: 正则表达式错误: %v", prefix, i, err)
+		}
+	}
+}
+
+// ParseTaskColor 读取任务的color配置,未配置时返回nil
+func ParseTaskColor(task gjson.Result) *bool {
+	value := task.Get("color")
+	if !value.Exists() || value.Type == gjson.Null {
+		return nil
+	}
+	color := value.Bool()
+	return &color
+}
+
+// ParseTaskResult 读取任务配置中的结果判定规则,未配置时返回nil
+func ParseTaskResult(task gjson.Result) *TaskResult {
+	raw := task.Get("result")
+	if !raw.IsObject() {
+		return nil
+	}
+	var result TaskResult
+	if err := json.Unmarshal([]byte(raw.Raw), &result); err != nil {
+		return nil
+	}
+	return &result
+}
+
+// TLSConfig HTTPS配置
+type TLSConfig struct {
+	Enable       bool   `json:"enable"`
+	Cert         string `json:"cert"`
+	Key          string `json:"key"`
+	MinVersion   string `json:"min_version"`
+	RedirectHttp bool   `json:"redirect_http"`
+	HttpPort     string `json:"http_port"`
+}
+
+// TOTPConfig 两步验证配置
+type TOTPConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Secret        string   `json:"secret"`
+	PendingSecret string   `json:"pending_secret"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LogConfig 系统日志配置
+type LogConfig struct {
+	Format       string            `json:"format"`        // 输出格式 text/json
+	Components   map[string]string `json:"components"`    // 各组件的日志级别,如 {"access":"warn"}
+	AccessFormat string            `json:"access_format"` // 访问日志格式 text/combined/off
+	MaxSizeMB    *int              `json:"max_size_mb"`   // 单个文件大小上限,0表示不按大小轮转
+	MaxAgeDays   *int              `json:"max_age_days"`  // 轮转文件保留天数
+	MaxBackups   *int              `json:"max_backups"`   // 轮转文件保留数量
+	Daily        *bool             `json:"daily"`         // 跨天时轮转
+	Compress     *bool             `json:"compress"`      // 压缩轮转文件
+}
+
+// TaskLogConfig 任务执行日志的保留策略,按任务分别计算,0表示不限制
+type TaskLogConfig struct {
+	MaxAgeDays *int         `json:"max_age_days"` // 保留天数,默认使用 log_clean_days
+	MaxRuns    *int         `json:"max_runs"`     // 保留的执行次数
+	MaxTotalMB *int         `json:"max_total_mb"` // 执行日志总大小
+	Output     *OutputLimit `json:"output"`       // 任务输出的默认限制
+}
+
+// Schema config.json的完整结构
+type Schema struct {
+	SchemaVersion        int            `json:"schema_version"`         // 配置结构版本
+	Name                 string         `json:"name"`                   // 系统名称
+	Username             string         `json:"username"`               // 管理员用户名
+	Password             string         `json:"password"`               // 管理员密码(bcrypt)
+	Port                 string         `json:"port"`                   // 端口
+	Listen               string         `json:"listen"`                 // 监听地址
+	BasePath             string         `json:"base_path"`              // 反向代理路径
+	TrustedProxies       []string       `json:"trusted_proxies"`        // 可信代理
+	CookieExpireDays     int            `json:"cookie_expire_days"`     // Cookie过期天数
+	LogCleanDays         int            `json:"log_clean_days"`         // 日志清理天数
+	AuditRetainDays      int            `json:"audit_retain_days"`      // 审计日志保留天数
+	ShutdownGraceSeconds int            `json:"shutdown_grace_seconds"` // 关闭时等待任务结束的秒数
+	ConfigWatch          *bool          `json:"config_watch"`           // 是否监听配置文件变化
+	HistoryLimit         int            `json:"history_limit"`          // 保留的配置历史版本数量
+	LogLevel             string         `json:"log_level"`              // 日志级别 debug/info/warn/error
+	Timezone             string         `json:"timezone"`               // 时区,如 Asia/Shanghai、UTC、Local
+	Log                  *LogConfig     `json:"log"`                    // 系统日志配置
+	TaskLog              *TaskLogConfig `json:"task_log"`               // 任务执行日志配置
+	Mask                 *MaskConfig    `json:"mask"`                   // 任务输出中敏感内容的隐藏规则
+	TLS                  *TLSConfig     `json:"tls"`                    // HTTPS配置
+	TOTP                 *TOTPConfig    `json:"totp"`                   // 两步验证配置
+	Task                 []Task         `json:"task"`                   // 任务列表
+}
+
+// ValidationError 配置校验错误,包含所有问题
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "配置校验失败: " + strings.Join(e.Errors, "; ")
+}
+
+// 支持的日志级别
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "warning": true, "error": true}
+
+// 支持的日志格式和访问日志格式
+var (
+	logFormats    = map[string]bool{"": true, "text": true, "json": true}
+	accessFormats = map[string]bool{"": true, "text": true, "combined": true, "off": true}
+)
+
+// 支持的最低TLS版本
+var tlsMinVersions = map[string]bool{"": true, "1.0": true, "1.1": true, "1.2": true, "1.3": true}
+
+// ParseSchema 解析配置,字段类型错误时返回错误。未知字段忽略,由 UnknownFields 单独报告
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, &ValidationError{Errors: []string{describeJSONError(err)}}
+	}
+	return &s, nil
+}
+
+// UnknownFields 配置中不属于配置结构的字段,如 task[0].foo。
+// 可能是旧版本遗留、手动修改时拼写错误或新版本写入的字段,只作为警告,不影响加载和写入
+func UnknownFields(data []byte) []string {
+	var fields []string
+	collectUnknownFields(gjson.ParseBytes(data), reflect.TypeOf(Schema{}), "", &fields)
+	return fields
+}
+
+// collectUnknownFields 按结构体的json标签递归检查字段,与json解析一样不区分大小写
+func collectUnknownFields(value gjson.Result, t reflect.Type, prefix string, fields *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if !value.IsObject() {
+			return
+		}
+		known := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			known[strings.ToLower(name)] = t.Field(i).Type
+		}
+		value.ForEach(func(key, v gjson.Result) bool {
+			path := key.String()
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			if ft, ok := known[strings.ToLower(key.String())]; ok {
+				collectUnknownFields(v, ft, path, fields)
+			} else {
+				*fields = append(*fields, path)
+			}
+			return true
+		})
+	case reflect.Slice:
+		for i, v := range value.Array() {
+			collectUnknownFields(v, t.Elem(), fmt.Sprintf("%s[%d]", prefix, i), fields)
+		}
+	}
+}
+
+// 字段路径中的数组下标,如 task.1.enable
+var indexRegex = regexp.MustCompile(`\.(\d+)`)
+
+// describeJSONError 将json解析错误转换为带字段路径的提示
+func describeJSONError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		field := indexRegex.ReplaceAllString(typeErr.Field, "[$1]")
+		return fmt.Sprintf("%s: 类型错误,需要%s,实际为%s", field, typeName(typeErr.Type.Kind().String()), typeErr.Value)
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("JSON格式错误(第%d字节): %v", syntaxErr.Offset, syntaxErr)
+	}
+	return err.Error()
+}
+
+// typeName 类型名称
+func typeName(kind string) string {
+	switch kind {
+	case "bool":
+		return "布尔值"
+	case "string":
+		return "字符串"
+	case "slice":
+		return "数组"
+	case "struct", "ptr":
+		return "对象"
+	case "int", "int64":
+		return "整数"
+	}
+	return kind
+}
+
+// Validate 校验配置的取值,返回所有错误
+func (s *Schema) Validate() error {
+	var errs []string
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if s.SchemaVersion > SchemaVersion {
+		add("schema_version: 配置版本%d高于程序支持的版本%d", s.SchemaVersion, SchemaVersion)
+	}
+	if s.Port != "" {
+		if port, err := strconv.Atoi(s.Port); err != nil || port <= 0 || port > 65535 {
+			add("port: 端口必须是1-65535之间的数字")
+		}
+	}
+	if s.CookieExpireDays < 0 {
+		add("cookie_expire_days: 不能为负数")
+	}
+	if s.LogCleanDays < 0 {
+		add("log_clean_days: 不能为负数")
+	}
+	if s.AuditRetainDays < 0 {
+		add("audit_retain_days: 不能为负数")
+	}
+	if s.ShutdownGraceSeconds < 0 {
+		add("shutdown_grace_seconds: 不能为负数")
+	}
+	if s.LogLevel != "" && !logLevels[strings.ToLower(s.LogLevel)] {
+		add("log_level: 不支持的日志级别 %s", s.LogLevel)
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			add("timezone: 无效的时区 %s", s.Timezone)
+		}
+	}
+	if s.Log != nil {
+		if !logFormats[s.Log.Format] {
+			add("log.format: 不支持的日志格式 %s", s.Log.Format)
+		}
+		if !accessFormats[s.Log.AccessFormat] {
+			add("log.access_format: 不支持的访问日志格式 %s", s.Log.AccessFormat)
+		}
+		for name, level := range s.Log.Components {
+			if !logLevels[strings.ToLower(level)] {
+				add("log.components.%s: 不支持的日志级别 %s", name, level)
+			}
+		}
+		if s.Log.MaxSizeMB != nil && *s.Log.MaxSizeMB < 0 {
+			add("log.max_size_mb: 不能为负数")
+		}
+		if s.Log.MaxAgeDays != nil && *s.Log.MaxAgeDays < 0 {
+			add("log.max_age_days: 不能为负数")
+		}
+		if s.Log.MaxBackups != nil && *s.Log.MaxBackups < 0 {
+			add("log.max_backups: 不能为负数")
+		}
+	}
+	if s.TaskLog != nil {
+		if s.TaskLog.MaxAgeDays != nil && *s.TaskLog.MaxAgeDays < 0 {
+			add("task_log.max_age_days: 不能为负数")
+		}
+		if s.TaskLog.MaxRuns != nil && *s.TaskLog.MaxRuns < 0 {
+			add("task_log.max_runs: 不能为负数")
+		}
+		if s.TaskLog.MaxTotalMB != nil && *s.TaskLog.MaxTotalMB < 0 {
+			add("task_log.max_total_mb: 不能为负数")
+		}
+		if s.TaskLog.Output != nil {
+			s.TaskLog.Output.validate("task_log.output", add)
+		}
+	}
+	if s.Mask != nil {
+		s.Mask.validate("mask", add)
+	}
+	if s.HistoryLimit < 0 {
+		add("history_limit: 不能为负数")
+	}
+	if s.TLS != nil {
+		if !tlsMinVersions[s.TLS.MinVersion] {
+			add("tls.min_version: 不支持的TLS版本 %s", s.TLS.MinVersion)
+		}
+		if (s.TLS.Cert == "") != (s.TLS.Key == "") {
+			add("tls: 证书和私钥路径需要同时设置")
+		}
+	}
+
+	names := map[string]bool{}
+	for i, t := range s.Task {
+		prefix := fmt.Sprintf("task[%d]", i)
+		if t.Name == "" {
+			add("%s.name: 任务名称不能为空", prefix)
+		} else {
+			prefix = fmt.Sprintf("task[%d](%s)", i, t.Name)
+			if names[t.Name] {
+				add("%s.name: 任务名称重复", prefix)
+			}
+			names[t.Name] = true
+		}
+		if t.Exec == "" {
+			add("%s.exec: 执行命令不能为空", prefix)
+		}
+		for j, spec := range t.Times {
+			if _, err := CronParser.Parse(spec); err != nil {
+				add("%s.times[%d]: 定时表达式错误[%s]: %v", prefix, j, spec, err)
+			}
+		}
+		if t.Output != nil {
+			t.Output.validate(prefix+".output", add)
+		}
+		if t.Mask != nil {
+			t.Mask.validate(prefix+".mask", add)
+		}
+		if t.Ansi != "" && t.Ansi != AnsiStrip && t.Ansi != AnsiPreserve && t.Ansi != AnsiHTML {
+			add("%s.ansi: 只能为 strip、preserve 或 html", prefix)
+		}
+		if t.Encoding != "" && !ValidEncoding(t.Encoding) {
+			add("%s.encoding: 不支持的编码[%s],可选 %s", prefix, t.Encoding, strings.Join(TaskEncodings, " "))
+		}
+		if t.Result != nil {
+			codes := map[int]bool{}
+			for _, code := range t.Result.SuccessCodes {
+				codes[code] = true
+			}
+			for _, code := range t.Result.WarningCodes {
+				if codes[code] {
+					add("%s.result.warning_codes: 退出码%d同时在success_codes中", prefix, code)
+				}
+			}
+			for j, pattern := range t.Result.FailurePatterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					add("%s.result.failure_patterns[%d]: 正则表达式错误: %v", prefix, j, err)
+				}
+			}
+			for j, pattern := range t.Result.WarningPatterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					add("%s.result.warning_patterns[%d]: 正则表达式错误: %v", prefix, j, err)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// ValidateConfig 校验配置内容,包括字段类型和取值
+func ValidateConfig(data []byte) error {
+	s, err := ParseSchema(data)
+	if err != nil {
+		return err
+	}
+	return s.Validate()
+}
@@ -0,0 +1,62 @@
+package serve
+
+import (
+	"fmt"
+	"time"
+	xwlog "xuanwu/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog 访问日志,按 log.access_format 配置的格式写入系统日志的access组件
+func (p *ApiData) AccessLog() gin.HandlerFunc {
+	logger := xwlog.Component("access")
+	return func(c *gin.Context) {
+		format := xwlog.AccessFormat()
+		if format == xwlog.AccessFormatOff {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		uri := c.Request.URL.RequestURI()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := xwlog.LevelInfo
+		if status >= 500 {
+			level = xwlog.LevelError
+		} else if status >= 400 {
+			level = xwlog.LevelWarn
+		}
+		user := c.GetString("username")
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+
+		if format == xwlog.AccessFormatCombined {
+			if user == "" {
+				user = "-"
+			}
+			logger.Log(level, fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %d "%s" "%s"`,
+				c.ClientIP(), user, start.Format("02/Jan/2006:15:04:05 -0700"),
+				c.Request.Method, uri, c.Request.Proto, status, size,
+				c.Request.Referer(), c.Request.UserAgent()), nil)
+			return
+		}
+
+		fields := map[string]interface{}{
+			"ip":         c.ClientIP(),
+			"size":       size,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if user != "" {
+			fields["user"] = user
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate).String(); errs != "" {
+			fields["error"] = errs
+		}
+		logger.Log(level, fmt.Sprintf("%s %s %d", c.Request.Method, uri, status), fields)
+	}
+}
@@ -0,0 +1,95 @@
+package serve
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	ADMIN_DIR    = "run"        // 管理socket所在目录,位于数据目录,只有运行用户可以访问
+	ADMIN_SOCKET = "admin.sock" // 本机管理socket文件名
+	AdminUser    = "cli"        // 通过管理socket操作时记录的用户
+)
+
+type adminCtxKey struct{}
+
+// 当前运行的管理socket服务,用于关闭
+var currentAdminServer *http.Server
+
+// AdminSocketPath 管理socket路径,只有运行用户可以连接
+func AdminSocketPath() string {
+	return pathutil.GetDataPath(filepath.Join(ADMIN_DIR, ADMIN_SOCKET))
+}
+
+// isAdminRequest 请求是否来自管理socket或命令行
+func isAdminRequest(req *http.Request) bool {
+	v, _ := req.Context().Value(adminCtxKey{}).(bool)
+	return v
+}
+
+// adminHandler 标记请求来自本机管理命令,免登录访问接口,路径不需要带反向代理前缀
+func (p *ApiData) adminHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(context.WithValue(req.Context(), adminCtxKey{}, true))
+		req.URL.Path = p.Listen.BasePath + req.URL.Path
+		req.RemoteAddr = "127.0.0.1:0"
+		next.ServeHTTP(w, req)
+	})
+}
+
+// startAdminServer 监听管理socket,供命令行与运行中的服务通信
+func (p *ApiData) startAdminServer(handler http.Handler) {
+	path := AdminSocketPath()
+	// socket按umask创建,之后才能修改权限,放在只有运行用户可以访问的目录中,创建前后其他用户都无法连接
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("创建管理socket目录失败,命令行将无法连接运行中的服务: %v", err)
+		return
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		log.Printf("修改管理socket目录权限失败,不开启管理socket: %v", err)
+		return
+	}
+	// 清理上次异常退出残留的socket文件
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		log.Printf("管理socket监听失败,命令行将无法连接运行中的服务: %v", err)
+		return
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		log.Printf("修改管理socket权限失败,不开启管理socket: %v", err)
+		return
+	}
+
+	srv := &http.Server{Handler: p.adminHandler(handler)}
+	serverLock.Lock()
+	currentAdminServer = srv
+	serverLock.Unlock()
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		log.Printf("管理socket服务异常: %v", err)
+	}
+}
+
+// LocalHandler 服务未运行时,命令行在进程内直接调用接口
+func LocalHandler(cfg gjson.Result) (http.Handler, error) {
+	p, err := newApiData(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	router, err := p.Router()
+	if err != nil {
+		return nil, err
+	}
+	return p.adminHandler(router), nil
+}
@@ -0,0 +1,212 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"xuanwu/audit"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const auditMaxBody = 64 * 1024 // 审计时最多解析的请求体大小
+
+// 审计记录中需要隐藏的字段
+var auditMaskFields = map[string]bool{
+	"password":     true,
+	"old_password": true,
+	"token":        true,
+	"ticket":       true,
+	"code":         true,
+}
+
+// auditWriter 记录响应内容,用于判断操作是否成功
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.body.Len() < auditMaxBody {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// currentUser 从上下文中获取当前登录用户
+func currentUser(c *gin.Context) string {
+	return c.GetString("username")
+}
+
+// taskSnapshot 获取任务当前配置,用于比较变更前后
+func taskSnapshot(name string) string {
+	if name == "" {
+		return ""
+	}
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return ""
+	}
+	for _, task := range cfg.Get("task").Array() {
+		if task.Get("name").String() == name {
+			return task.Raw
+		}
+	}
+	return ""
+}
+
+// summarizeBody 生成请求内容摘要,隐藏敏感字段,文件内容只记录长度和哈希
+func summarizeBody(data map[string]interface{}) map[string]interface{} {
+	summary := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		switch {
+		case auditMaskFields[k]:
+			summary[k] = "***"
+		case k == "content":
+			s, _ := v.(string)
+			summary[k] = gin.H{"length": len(s), "sha256": lib.SHA256(s)}
+		default:
+			summary[k] = v
+		}
+	}
+	return summary
+}
+
+// Audit 审计中间件,记录修改类操作的用户、IP、对象和变更摘要
+func (p *ApiData) Audit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body map[string]interface{}
+		contentType := c.ContentType()
+		if strings.HasPrefix(contentType, "multipart/") {
+			// 上传文件只记录路径和文件名
+			if form, err := c.MultipartForm(); err == nil {
+				var names []string
+				for _, files := range form.File {
+					for _, f := range files {
+						names = append(names, f.Filename)
+					}
+				}
+				body = map[string]interface{}{"path": c.PostForm("path"), "files": names}
+			}
+		} else if c.Request.Body != nil && c.Request.ContentLength != 0 {
+			raw, _ := io.ReadAll(io.LimitReader(c.Request.Body, auditMaxBody+1))
+			rest := c.Request.Body
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), rest))
+			if len(raw) <= auditMaxBody {
+				json.Unmarshal(raw, &body)
+			}
+		}
+		if body == nil {
+			body = map[string]interface{}{}
+		}
+		for k, v := range c.Request.URL.Query() {
+			if _, ok := body[k]; !ok && len(v) > 0 {
+				body[k] = v[0]
+			}
+		}
+
+		// 操作对象
+		target, _ := body["name"].(string)
+		if target == "" {
+			target, _ = body["path"].(string)
+		}
+
+		// 任务类操作记录变更前后的配置
+		var before string
+		isTask := strings.HasPrefix(action, "task.")
+		if isTask {
+			before = taskSnapshot(target)
+		}
+
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		entry := audit.Entry{
+			Time:   time.Now(),
+			User:   currentUser(c),
+			IP:     c.ClientIP(),
+			Action: action,
+			Target: target,
+		}
+		if entry.User == "" {
+			entry.User, _ = body["username"].(string)
+		}
+
+		detail := gin.H{"request": summarizeBody(body)}
+		if isTask {
+			after := taskSnapshot(target)
+			if before != after {
+				var b, a interface{}
+				json.Unmarshal([]byte(before), &b)
+				json.Unmarshal([]byte(after), &a)
+				detail["before"] = b
+				detail["after"] = a
+			}
+		}
+		entry.Detail = detail
+
+		res := gjson.ParseBytes(w.body.Bytes())
+		entry.Success = w.Status() < 400 && res.Get("code").Int() == 0
+		entry.Message = res.Get("message").String()
+		audit.Record(entry)
+	}
+}
+
+// HandlerAuditList 分页查询审计日志
+// 支持参数 page size user action target start end, 时间格式为 2006-01-02 或 2006-01-02 15:04:05
+func (p *ApiData) HandlerAuditList(c *gin.Context) {
+	q := audit.Query{
+		User:   c.Query("user"),
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+	}
+	q.Page, _ = strconv.Atoi(c.Query("page"))
+	q.Size, _ = strconv.Atoi(c.Query("size"))
+
+	var ok bool
+	if q.Start, ok = parseQueryTime(c.Query("start"), false); !ok {
+		r.ErrMesage(c, "开始时间格式错误")
+		return
+	}
+	if q.End, ok = parseQueryTime(c.Query("end"), true); !ok {
+		r.ErrMesage(c, "结束时间格式错误")
+		return
+	}
+
+	list, total, err := audit.Search(q)
+	if err != nil {
+		r.ErrMesage(c, "读取审计日志失败")
+		return
+	}
+	r.OkData(c, gin.H{
+		"list":        list,
+		"total":       total,
+		"retain_days": audit.GetRetainDays(),
+	})
+}
+
+// parseQueryTime 解析查询时间,只有日期的结束时间取当天结束
+func parseQueryTime(s string, end bool) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, true
+}
@@ -2,9 +2,12 @@ package serve
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strings"
 	"time"
+	"xuanwu/config"
 	r "xuanwu/gin/response"
 	"xuanwu/lib"
 
@@ -21,19 +24,47 @@ func (p *ApiData) ClearUserToken(c *gin.Context) {
 	}
 	
 	// 清除cookie
-	c.SetCookie("cookie", "", -1, "/", "", false, false)
+	c.SetCookie("cookie", "", -1, p.cookiePath(), "", p.TLS.Enable, false)
+}
+
+// 无需登录即可访问的接口
+var publicApi = map[string]bool{
+	"/api/auth/login":     true,
+	"/api/auth/login/2fa": true,
+	"/api/install/status": true,
+	"/api/install/setup":  true,
+}
+
+// cookiePath cookie作用路径,配置了反向代理路径时只在该路径下生效
+func (p *ApiData) cookiePath() string {
+	if p.Listen.BasePath == "" {
+		return "/"
+	}
+	return p.Listen.BasePath
 }
 
 func (p *ApiData) CookieHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		//这里做用户认证处理
+		//去掉反向代理路径前缀后再判断
+		uri := strings.TrimPrefix(c.Request.URL.Path, p.Listen.BasePath)
+		fullPath := strings.TrimPrefix(c.FullPath(), p.Listen.BasePath)
 		//判断请求是不是xwui后台静态资源,不做权限认证
-		if strings.HasPrefix(c.Request.RequestURI, "/xwui") {
+		if strings.HasPrefix(uri, "/xwui") {
 			c.Next()
-		} else if strings.HasPrefix(c.Request.RequestURI, "/api") {
+		} else if strings.HasPrefix(uri, "/api") {
+			//未安装时,除安装接口外全部返回未安装
+			if !config.IsInstalled() && !strings.HasPrefix(fullPath, "/api/install/") {
+				r.NotInstallMesage(c)
+				c.Abort()
+				return
+			}
 			cookie, err := c.Cookie("cookie")
-			//cookie不存在,用户认证失败
-			if c.FullPath() != "/api/auth/login" {
+			if isAdminRequest(c.Request) {
+				// 本机管理命令,socket只有运行用户可以连接,无需登录
+				c.Set("username", AdminUser)
+			} else if !publicApi[fullPath] {
+				//cookie不存在,用户认证失败
 				if err != nil {
 					//如果cookie为空,就获取Authorization
 					if _, ok := c.Request.Header["Authorization"]; ok {
@@ -63,10 +94,16 @@ func (p *ApiData) CookieHandler() gin.HandlerFunc {
 				}
 				p.Cookie = string(username)
 				p.Token = cookie
+				// token格式为 用户名_时间戳
+				user := string(username)
+				if i := strings.LastIndex(user, "_"); i > 0 {
+					user = user[:i]
+				}
+				c.Set("username", user)
 			}
 		} else {
 			//重定向
-			c.Redirect(http.StatusMovedPermanently, "/xwui/")
+			c.Redirect(http.StatusMovedPermanently, p.Listen.BasePath+"/xwui/")
 		}
 		// after request  请求前处理
 		c.Next()
@@ -85,19 +122,60 @@ func (p *ApiData) LoginHandle(c *gin.Context) {
 		r.ErrMesage(c, "请求参数错误")
 		return
 	}
+	// 按IP和账号分别限流
+	ip := c.ClientIP()
+	keys := []string{"ip:" + ip, "user:" + req.Username}
+	limiter := lib.GetLoginLimiter()
+	if wait := limiter.Check(keys...); wait > 0 {
+		log.Printf("登录被限制[ip=%s user=%s]: 需等待%v", ip, req.Username, wait.Round(time.Second))
+		r.ErrMesage(c, fmt.Sprintf("登录尝试过于频繁,请%d秒后再试", int(wait.Seconds())+1))
+		return
+	}
+
 	res := GetUserInfo()
-	if res.Username != req.Username { //没有查到用户数据
-		r.ErrMesage(c, "用户名错误")
+	//前端传过来的参数是sha256,服务端存储的是bcrypt,旧版本的sha256在登录成功后自动升级
+	ok, needRehash := lib.CheckPassword(res.Password, req.Password)
+	if res.Username == "" || res.Username != req.Username || !ok {
+		locked := limiter.Fail(keys...)
+		log.Printf("登录失败[ip=%s user=%s]", ip, req.Username)
+		if locked {
+			log.Printf("登录失败次数过多,已锁定%v[ip=%s user=%s]", lib.LoginLockDuration, ip, req.Username)
+		}
+		//不区分用户名错误和密码错误
+		r.ErrMesage(c, "用户名或密码错误")
 		return
 	}
-	//密码在设置时候就加密存储,传过来的参数是sha256,直接比较
-	if res.Password != req.Password {
-		r.ErrMesage(c, "密码错误")
+	limiter.Success(keys...)
+	if needRehash {
+		if err := UpgradePasswordHash(req.Password); err != nil {
+			log.Printf("密码哈希升级失败: %v", err)
+		} else {
+			log.Println("密码哈希已升级为bcrypt")
+		}
+	}
+
+	// 开启了两步验证时,需要再校验动态验证码
+	if cfg, err := config.ReadConfigFileToJson(); err == nil && IsTwoFactorEnabled(cfg) {
+		ticket, err := newLoginTicket(req.Username)
+		if err != nil {
+			r.ErrMesage(c, "生成登录凭据失败")
+			return
+		}
+		r.OkMesageData(c, "请输入两步验证码", gin.H{
+			"need_2fa": true,
+			"ticket":   ticket,
+		})
 		return
 	}
-	
+
+	p.issueToken(c, req.Username)
+}
+
+// issueToken 生成token并写入cookie
+func (p *ApiData) issueToken(c *gin.Context, username string) {
+	c.Set("username", username)
 	// 生成token时加入时间戳确保唯一性
-	tokenStr := fmt.Sprintf("%s_%d", req.Username, time.Now().Unix())
+	tokenStr := fmt.Sprintf("%s_%d", username, time.Now().Unix())
 	//加密
 	str, _ := lib.EncryptByAes([]byte(tokenStr))
 	
@@ -105,7 +183,8 @@ func (p *ApiData) LoginHandle(c *gin.Context) {
 	expireSeconds := GetCookieExpireDays() * 24 * 60 * 60
 	
 	//设置cookie
-	c.SetCookie("cookie", str, expireSeconds, "/", "", false, false)
+	//启用HTTPS时只通过安全连接发送cookie
+	c.SetCookie("cookie", str, expireSeconds, p.cookiePath(), "", p.TLS.Enable, false)
 	
 	r.OkMesageData(c, "登录成功", gin.H{
 		"token":  str,
@@ -118,3 +197,23 @@ func (p *ApiData) LogoutHandler(c *gin.Context) {
 	p.ClearUserToken(c)
 	r.OkMesage(c, "退出登录成功")
 }
+
+// HandlerLockoutList 获取登录失败及锁定记录
+func (p *ApiData) HandlerLockoutList(c *gin.Context) {
+	r.OkData(c, lib.GetLoginLimiter().List())
+}
+
+// HandlerLockoutClear 清除登录锁定,请求体为 {"key": "..."},key为空或没有请求体时清除全部
+func (p *ApiData) HandlerLockoutClear(c *gin.Context) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	key := req.Key
+	n := lib.GetLoginLimiter().Clear(key)
+	log.Printf("用户[%s]清除登录锁定: key=%q, 共%d条", p.Cookie, key, n)
+	r.OkMesage(c, fmt.Sprintf("已清除%d条记录", n))
+}
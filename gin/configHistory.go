@@ -0,0 +1,81 @@
+package serve
+
+import (
+	"log"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snapshotRaw 获取版本的配置内容,current 表示当前配置
+func snapshotRaw(id string) (string, error) {
+	if id == "" || id == "current" {
+		cfg, err := config.ReadConfigFileToJson()
+		if err != nil {
+			return "", err
+		}
+		return cfg.Raw, nil
+	}
+	snap, err := config.GetSnapshot(id)
+	if err != nil {
+		return "", err
+	}
+	return string(snap.Config), nil
+}
+
+// HandlerConfigHistory 获取配置历史版本列表
+func (p *ApiData) HandlerConfigHistory(c *gin.Context) {
+	list, err := config.ListSnapshots()
+	if err != nil {
+		r.ErrMesage(c, "读取配置历史失败")
+		return
+	}
+	r.OkData(c, list)
+}
+
+// HandlerConfigHistoryDiff 对比两个版本,to 为空时与当前配置对比
+func (p *ApiData) HandlerConfigHistoryDiff(c *gin.Context) {
+	from := c.Query("from")
+	if from == "" {
+		r.ErrMesage(c, "请指定要对比的版本")
+		return
+	}
+	oldRaw, err := snapshotRaw(from)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	newRaw, err := snapshotRaw(c.Query("to"))
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	r.OkData(c, config.Diff(oldRaw, newRaw))
+}
+
+// HandlerConfigRollback 回滚到指定版本并重新加载任务,账号密码和两步验证不会回滚
+func (p *ApiData) HandlerConfigRollback(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	username := c.GetString("username")
+	if err := config.Rollback(req.ID, config.Change{User: username, Reason: "回滚到版本 " + req.ID}); err != nil {
+		r.ErrMesage(c, "回滚失败: "+err.Error())
+		return
+	}
+	log.Printf("配置已回滚到版本%s[user=%s]", req.ID, username)
+
+	result, err := xuanwu.Reload()
+	if err != nil {
+		r.ErrMesage(c, "配置已回滚,但重新加载失败: "+err.Error())
+		return
+	}
+	r.OkMesageData(c, "配置已回滚", result)
+}
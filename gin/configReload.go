@@ -0,0 +1,18 @@
+package serve
+
+import (
+	r "xuanwu/gin/response"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandlerConfigReload 重新加载config.json,校验失败时不应用并返回错误
+func (p *ApiData) HandlerConfigReload(c *gin.Context) {
+	result, err := xuanwu.Reload()
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	r.OkMesageData(c, "配置已重新加载", result)
+}
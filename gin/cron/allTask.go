@@ -2,12 +2,11 @@ package cron
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"xuanwu/config"
 	r "xuanwu/gin/response"
-	"xuanwu/lib/pathutil"
 	mycron "xuanwu/xuanwu"
 
 	"github.com/gin-gonic/gin"
@@ -15,6 +14,9 @@ import (
 	"github.com/tidwall/sjson"
 )
 
+// 任务不存在,用于在修改配置时中止写入
+var errTaskNotFound = errors.New("任务不存在")
+
 // 放处理过的json字符串
 type JsonParams struct {
 	data string
@@ -64,86 +66,72 @@ func HandlerAddTask(c *gin.Context) {
 	jp.Set("workdir", jsonData["workdir"])
 	jp.Set("exec", jsonData["exec"])
 	jp.Set("enable", jsonData["enable"])
-
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return
+	// 结果判定规则、输出限制、输出编码、隐藏规则和转义序列处理可选,更新时未提供则保留原有配置,为null时删除
+	optional := map[string]interface{}{}
+	for _, key := range []string{"result", "output", "encoding", "mask", "ansi", "color"} {
+		if value, ok := jsonData[key]; ok {
+			optional[key] = value
+			if value != nil {
+				jp.Set(key, value)
+			}
+		}
 	}
 
-	// 检查任务是否已存在
+	// 检查任务是否已存在,在配置锁内完成读取和写入
 	isUpdate := false
-	result := gjson.Get(cfg.Raw, "task.#.name")
-	for i, isname := range result.Array() {
-		if isname.String() == name {
-			isUpdate = true
-			// 更新配置文件
-			jp := &JsonParams{data: cfg.Raw}
-			jp.Set(fmt.Sprintf("task.%v.times", i), times)
-			jp.Set(fmt.Sprintf("task.%v.workdir", i), workdir)
-			jp.Set(fmt.Sprintf("task.%v.exec", i), exec)
-			jp.Set(fmt.Sprintf("task.%v.enable", i), jsonData["enable"])
-			configPath := pathutil.GetDataPath("config.json")
-			err := config.WriteConfigFile(configPath, []byte(jp.data))
-			if err != nil {
-				r.ErrMesage(c, "更新失败,配置文件写入失败")
-				return
+	var saved gjson.Result // 写入配置的任务
+	change := config.Change{User: c.GetString("username"), Reason: "保存任务 " + name}
+	err := config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		isUpdate = false
+		result := gjson.Get(cfg.Raw, "task.#.name")
+		for i, isname := range result.Array() {
+			if isname.String() == name {
+				isUpdate = true
+				// 更新配置文件
+				jp := &JsonParams{data: cfg.Raw}
+				jp.Set(fmt.Sprintf("task.%v.times", i), times)
+				jp.Set(fmt.Sprintf("task.%v.workdir", i), workdir)
+				jp.Set(fmt.Sprintf("task.%v.exec", i), exec)
+				jp.Set(fmt.Sprintf("task.%v.enable", i), jsonData["enable"])
+				for key, value := range optional {
+					if value != nil {
+						jp.Set(fmt.Sprintf("task.%v.%s", i, key), value)
+					} else {
+						jp.data, _ = sjson.Delete(jp.data, fmt.Sprintf("task.%v.%s", i, key))
+					}
+				}
+				saved = gjson.Get(jp.data, fmt.Sprintf("task.%v", i))
+				return jp.data, nil
 			}
-			break
 		}
-	}
 
-	if !isUpdate {
 		// 添加新任务
+		saved = gjson.Parse(jp.data)
 		var newObj map[string]interface{}
 		json.Unmarshal([]byte(jp.data), &newObj)
-		value, _ := sjson.Set(cfg.Raw, "task.-1", newObj)
-		configPath := pathutil.GetDataPath("config.json")
-		err = config.WriteConfigFile(configPath, []byte(value))
-		if err != nil {
+		return sjson.Set(cfg.Raw, "task.-1", newObj)
+	})
+	var validErr *config.ValidationError
+	if errors.As(err, &validErr) {
+		r.ErrMesage(c, validErr.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("任务配置写入失败: %v", err)
+		if isUpdate {
+			r.ErrMesage(c, "更新失败,配置文件写入失败")
+		} else {
 			r.ErrMesage(c, "添加失败,配置文件写入失败")
-			return
 		}
+		return
 	}
 
 	// 如果enable为true，启用任务
 	if enable, ok := jsonData["enable"].(bool); ok && enable {
-		// 先禁用任务（如果存在）
-		for _, e := range mycron.C.Entries() {
-			if taskInfo, exists := mycron.TaskData[e.ID]; exists && taskInfo.Name == name {
-				mycron.C.Remove(e.ID)
-				// 关闭日志文件
-				if taskInfo.Writer != nil {
-					taskInfo.Writer.Close()
-				}
-				// 停止写入日志
-				taskInfo.Log.SetOutput(io.Discard)
-				// 从映射表中删除
-				delete(mycron.TaskData, e.ID)
-			}
-		}
-
-		// 添加到cron
-		TaskData := mycron.TaskInfo{
-			Name: name,
-			Times: func() []string {
-				timesArray, ok := times.([]interface{})
-				if !ok {
-					return []string{}
-				}
-				var result []string
-				for _, t := range timesArray {
-					if str, ok := t.(string); ok {
-						result = append(result, str)
-					}
-				}
-				return result
-			}(),
-			WorkDir: workdir.(string),
-			Exec:    exec.(string),
-			Enable:  true,
-		}
-		mycron.AddRunFunc(TaskData)
+		// 添加到cron,已存在时替换
+		TaskData := mycron.TaskFromConfig(saved)
+		TaskData.Enable = true
+		mycron.ReplaceTask(TaskData)
 	}
 
 	if isUpdate {
@@ -160,24 +148,24 @@ func HandlerDeleteTask(c *gin.Context) {
 		r.ErrMesage(c, "任务名称不能为空")
 		return
 	}
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return
-	}
-	result := gjson.Get(cfg.Raw, "task.#.name")
-	for i, isname := range result.Array() {
-		if isname.String() == name {
-			value, _ := sjson.Delete(cfg.Raw, fmt.Sprintf("task.%v", i))
-			configPath := pathutil.GetDataPath("config.json")
-			err := config.WriteConfigFile(configPath, []byte(value))
-			if err != nil {
-				r.ErrMesage(c, "删除失败,配置文件写入失败")
-				return
+	change := config.Change{User: c.GetString("username"), Reason: "删除任务 " + name}
+	err := config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		result := gjson.Get(cfg.Raw, "task.#.name")
+		for i, isname := range result.Array() {
+			if isname.String() == name {
+				return sjson.Delete(cfg.Raw, fmt.Sprintf("task.%v", i))
 			}
-			r.OkMesage(c, "删除成功")
-			return
 		}
+		return "", errTaskNotFound
+	})
+	if err == errTaskNotFound {
+		r.ErrMesage(c, "删除失败,任务不存在")
+		return
+	}
+	if err != nil {
+		log.Printf("删除任务配置写入失败: %v", err)
+		r.ErrMesage(c, "删除失败,配置文件写入失败")
+		return
 	}
-	r.ErrMesage(c, "删除失败,任务不存在")
+	r.OkMesage(c, "删除成功")
 }
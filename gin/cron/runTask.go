@@ -3,15 +3,12 @@ package cron
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"log"
-	"os"
 	"strconv"
 	"xuanwu/config"
 	r "xuanwu/gin/response"
-	"xuanwu/lib/pathutil"
-	mycron "xuanwu/xuanwu"
 	xwlog "xuanwu/log"
+	mycron "xuanwu/xuanwu"
 
 	"github.com/gin-gonic/gin"
 	"github.com/tidwall/gjson"
@@ -20,14 +17,30 @@ import (
 
 // TaskInfo 完整的任务信息结构
 type TaskInfo struct {
-	ID      string   `json:"id"`      // 运行时ID
-	Next    string   `json:"next"`    // 下次执行时间
-	Name    string   `json:"name"`    // 任务名称
-	Times   []string `json:"times"`   // 定时表达式
-	WorkDir string   `json:"workdir"` // 工作目录
-	Exec    string   `json:"exec"`    // 执行命令
-	Enable  bool     `json:"enable"`  // 是否启用
-	Status  string   `json:"status"`  // 运行状态：running/stopped
+	ID       string              `json:"id"`                 // 运行时ID
+	Next     string              `json:"next"`               // 下次执行时间
+	Name     string              `json:"name"`               // 任务名称
+	Times    []string            `json:"times"`              // 定时表达式
+	WorkDir  string              `json:"workdir"`            // 工作目录
+	Exec     string              `json:"exec"`               // 执行命令
+	Enable   bool                `json:"enable"`             // 是否启用
+	Status   string              `json:"status"`             // 运行状态：running/stopped
+	Result   *config.TaskResult  `json:"result,omitempty"`   // 执行结果判定规则
+	Output   *config.OutputLimit `json:"output,omitempty"`   // 输出限制
+	Encoding string              `json:"encoding,omitempty"` // 输出编码
+	Mask     *config.MaskConfig  `json:"mask,omitempty"`     // 输出中需要隐藏的内容
+	Ansi     string              `json:"ansi,omitempty"`     // 转义序列的处理方式
+	Color    *bool               `json:"color,omitempty"`    // 是否要求输出颜色
+	LastRun  *xwlog.RunInfo      `json:"last_run"`           // 最近一次执行,没有执行记录时为null
+}
+
+// getLastRun 读取任务最近一次执行的信息
+func getLastRun(name string) *xwlog.RunInfo {
+	runs, err := xwlog.ListRuns(name)
+	if err != nil || len(runs) == 0 {
+		return nil
+	}
+	return &runs[0]
 }
 
 // HandlerTaskList 获取所有任务列表（包含运行状态）
@@ -45,25 +58,32 @@ func HandlerTaskList(c *gin.Context) {
 	
 	// 获取所有运行中任务的映射
 	runningTasks := make(map[string]cron.EntryID)
-	for id, task := range mycron.TaskData {
+	for id, task := range mycron.TaskSnapshot() {
 		runningTasks[task.Name] = id
 	}
 	
 	// 遍历配置中的所有任务
 	tasks.ForEach(func(key, value gjson.Result) bool {
 		task := TaskInfo{
-			Name:    value.Get("name").String(),
-			Times:   func() []string {
+			Name:     value.Get("name").String(),
+			Times:    func() []string {
 				var times []string
 				for _, t := range value.Get("times").Array() {
 					times = append(times, t.String())
 				}
 				return times
 			}(),
-			WorkDir: value.Get("workdir").String(),
-			Exec:    value.Get("exec").String(),
-			Enable:  value.Get("enable").Bool(),
-			Status:  "stopped", // 默认状态为停止
+			WorkDir:  value.Get("workdir").String(),
+			Exec:     value.Get("exec").String(),
+			Enable:   value.Get("enable").Bool(),
+			Status:   "stopped", // 默认状态为停止
+			Result:   config.ParseTaskResult(value),
+			Output:   config.ParseOutputLimit(value.Get("output")),
+			Encoding: value.Get("encoding").String(),
+			Mask:     config.ParseMaskConfig(value.Get("mask")),
+			Ansi:     value.Get("ansi").String(),
+			Color:    config.ParseTaskColor(value),
+			LastRun:  getLastRun(value.Get("name").String()),
 		}
 		
 		// 如果任务正在运行，添加运行时信息
@@ -87,17 +107,28 @@ func HandlerTaskList(c *gin.Context) {
 
 // 执行任务请求参数
 type executeTaskRequest struct {
-	Name    string `json:"name" binding:"required"` // 任务名称
-	Exec    string `json:"exec"`                    // 执行的命令
-	WorkDir string `json:"workdir"`                 // 工作目录
+	Name     string `json:"name" binding:"required"` // 任务名称
+	Exec     string `json:"exec"`                    // 执行的命令
+	WorkDir  string `json:"workdir"`                 // 工作目录
+	Encoding string `json:"encoding"`                // 输出编码,临时执行时使用
+	Ansi     string `json:"ansi"`                    // 转义序列的处理方式,临时执行时使用
+	Color    *bool  `json:"color"`                   // 是否要求输出颜色,临时执行时使用
 }
 
 // 执行任务响应数据
 type executeTaskResponse struct {
-	Message string `json:"message"` // 执行状态信息
-	Output  string `json:"output"`  // 执行输出结果
+	Message   string `json:"message"`             // 执行状态信息
+	Output    string `json:"output"`              // 执行输出结果
+	RunID     string `json:"run_id"`              // 执行ID,对应执行日志
+	Status    string `json:"status"`              // 执行结果：success/warning/failure
+	ExitCode  int    `json:"exit_code"`           // 退出码,未能获取时为-1
+	Reason    string `json:"reason,omitempty"`    // 判定为警告或失败的原因
+	Truncated bool   `json:"truncated,omitempty"` // 输出超过限制被截断
 }
 
+// 临时执行的命令记录日志使用的名称
+const TEMP_RUN_NAME = "run_temp"
+
 /* 立即执行任务 */
 func HandlerExecuteTask(c *gin.Context) {
 	var req executeTaskRequest
@@ -108,8 +139,9 @@ func HandlerExecuteTask(c *gin.Context) {
 
 	// 创建一个内存缓冲区用于收集输出
 	var memLog bytes.Buffer
-	var execErr error
+	var result xwlog.RunResult
 	var taskOutput string
+	var runID string
 
 	// 如果只提供name参数,从任务列表中查找并执行
 	if req.Exec == "" && req.WorkDir == "" {
@@ -125,19 +157,8 @@ func HandlerExecuteTask(c *gin.Context) {
 		tasks.ForEach(func(key, value gjson.Result) bool {
 			if value.Get("name").String() == req.Name {
 				found = true
-				// 初始化日志文件
-				logname := fmt.Sprintf("%s.log", req.Name)
-				_, file := xwlog.LogInitWithConfig(logname, &xwlog.LogConfig{TaskLogFormat: true})
-				if file != nil {
-					defer file.Close()
-				}
-
-				// 创建多重输出(同时写入文件和内存)
-				multiWriter := io.MultiWriter(file, &memLog)
-				combinedLog := log.New(multiWriter, "", 0)
-
-				// 同步执行任务
-				execErr = mycron.ExecTask(value.Get("exec").String(), value.Get("workdir").String(), combinedLog)
+				// 同步执行任务,输出同时写入执行日志和内存
+				runID, result = mycron.RunTask(mycron.TaskFromConfig(value), &memLog)
 				taskOutput = memLog.String()
 				return false
 			}
@@ -154,34 +175,64 @@ func HandlerExecuteTask(c *gin.Context) {
 			r.ErrMesage(c, "缺少exec参数")
 			return
 		}
-
-		// 初始化日志
-		_, file := xwlog.LogInitWithConfig("run_temp.log", &xwlog.LogConfig{TaskLogFormat: true})
-		if file != nil {
-			defer file.Close()
+		if req.Encoding != "" && !config.ValidEncoding(req.Encoding) {
+			r.ErrMesage(c, "不支持的输出编码: "+req.Encoding)
+			return
+		}
+		if req.Ansi != "" && req.Ansi != config.AnsiStrip && req.Ansi != config.AnsiPreserve && req.Ansi != config.AnsiHTML {
+			r.ErrMesage(c, "ansi只能为 strip、preserve 或 html")
+			return
 		}
 
-		// 创建多重输出
-		multiWriter := io.MultiWriter(file, &memLog)
-		combinedLog := log.New(multiWriter, "", 0)
-
-		// 同步执行任务
-		execErr = mycron.ExecTask(req.Exec, req.WorkDir, combinedLog)
+		// 同步执行任务,日志记录在 run_temp 下
+		runID, result = mycron.RunTask(mycron.TaskInfo{
+			Name:     TEMP_RUN_NAME,
+			Exec:     req.Exec,
+			WorkDir:  req.WorkDir,
+			Encoding: req.Encoding,
+			Ansi:     req.Ansi,
+			Color:    req.Color,
+		}, &memLog)
 		taskOutput = memLog.String()
 	}
 
 	// 准备响应数据
 	response := executeTaskResponse{
-		Message: "任务执行完成",
-		Output:  taskOutput,
+		Message:   "任务执行完成",
+		Output:    taskOutput,
+		RunID:     runID,
+		Status:    result.Status,
+		ExitCode:  result.ExitCode,
+		Reason:    result.Reason,
+		Truncated: result.Truncated,
 	}
-	if execErr != nil {
-		response.Message = fmt.Sprintf("任务执行失败: %v", execErr)
+	switch result.Status {
+	case xwlog.RunFailure:
+		response.Message = "任务执行失败: " + mycron.ResultMessage(result)
+	case xwlog.RunWarning:
+		response.Message = "任务执行完成,有警告: " + mycron.ResultMessage(result)
 	}
 
 	r.OkData(c, response)
 }
 
+// setTaskEnable 在配置锁内修改任务的启用状态,返回修改后的任务配置
+func setTaskEnable(name string, enable bool, change config.Change) (gjson.Result, error) {
+	var task gjson.Result
+	err := config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		for i, value := range cfg.Get("task").Array() {
+			if value.Get("name").String() == name {
+				task = value
+				jp := &JsonParams{data: cfg.Raw}
+				jp.Set(fmt.Sprintf("task.%v.enable", i), enable)
+				return jp.data, nil
+			}
+		}
+		return "", errTaskNotFound
+	})
+	return task, err
+}
+
 /* 启用任务 */
 func HandlerEnableTask(c *gin.Context) {
 	name := c.Query("name")
@@ -190,51 +241,22 @@ func HandlerEnableTask(c *gin.Context) {
 		return
 	}
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return
-	}
-
 	// 查找并更新任务状态
-	found := false
-	result := cfg.Get("task")
-	for i, task := range result.Array() {
-		if task.Get("name").String() == name {
-			found = true
-			// 更新配置文件
-			jp := &JsonParams{data: cfg.Raw}
-			jp.Set(fmt.Sprintf("task.%v.enable", i), true)
-			configPath := pathutil.GetDataPath("config.json")
-			err := config.WriteConfigFile(configPath, []byte(jp.data))
-			if err != nil {
-				r.ErrMesage(c, "启用失败,配置文件写入失败")
-				return
-			}
-
-			// 添加到cron
-			TaskData := mycron.TaskInfo{
-				Name: task.Get("name").String(),
-				Times: func() []string {
-					var times []string
-					for _, t := range task.Get("times").Array() {
-						times = append(times, t.String())
-					}
-					return times
-				}(),
-				WorkDir: task.Get("workdir").String(),
-				Exec:    task.Get("exec").String(),
-				Enable:  true,
-			}
-			mycron.AddRunFunc(TaskData)
-			break
-		}
-	}
-
-	if !found {
+	task, err := setTaskEnable(name, true, config.Change{User: c.GetString("username"), Reason: "启用任务 " + name})
+	if err == errTaskNotFound {
 		r.ErrMesage(c, "任务不存在")
 		return
 	}
+	if err != nil {
+		log.Printf("启用任务配置写入失败: %v", err)
+		r.ErrMesage(c, "启用失败,配置文件写入失败")
+		return
+	}
+
+	// 添加到cron,已在运行时替换,避免重复调度
+	TaskData := mycron.TaskFromConfig(task)
+	TaskData.Enable = true
+	mycron.ReplaceTask(TaskData)
 
 	r.OkMesage(c, "启用成功")
 }
@@ -247,50 +269,20 @@ func HandlerDisableTask(c *gin.Context) {
 		return
 	}
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return
-	}
-
 	// 查找并更新任务状态
-	found := false
-	result := cfg.Get("task")
-	for i, task := range result.Array() {
-		if task.Get("name").String() == name {
-			found = true
-			// 更新配置文件
-			jp := &JsonParams{data: cfg.Raw}
-			jp.Set(fmt.Sprintf("task.%v.enable", i), false)
-			configPath := pathutil.GetDataPath("config.json")
-			err := config.WriteConfigFile(configPath, []byte(jp.data))
-			if err != nil {
-				r.ErrMesage(c, "禁用失败,配置文件写入失败")
-				return
-			}
-
-			// 从cron中移除任务
-			for _, e := range mycron.C.Entries() {
-				if taskInfo, exists := mycron.TaskData[e.ID]; exists && taskInfo.Name == name {
-					mycron.C.Remove(e.ID)
-					// 关闭日志文件
-					if file, ok := taskInfo.Writer.(*os.File); ok {
-						file.Close()
-					}
-					// 停止写入日志
-					taskInfo.Log.SetOutput(io.Discard)
-					// 从映射表中删除
-					delete(mycron.TaskData, e.ID)
-				}
-			}
-			break
-		}
-	}
-
-	if !found {
+	_, err := setTaskEnable(name, false, config.Change{User: c.GetString("username"), Reason: "禁用任务 " + name})
+	if err == errTaskNotFound {
 		r.ErrMesage(c, "任务不存在")
 		return
 	}
+	if err != nil {
+		log.Printf("禁用任务配置写入失败: %v", err)
+		r.ErrMesage(c, "禁用失败,配置文件写入失败")
+		return
+	}
+
+	// 从cron中移除任务
+	mycron.RemoveTask(name)
 
 	r.OkMesage(c, "禁用成功")
 }
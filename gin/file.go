@@ -24,7 +24,7 @@ type FileInfo struct {
 // 验证文件路径是否在DATA_DIR下，返回完整路径，如果不合法返回空字符串
 func validatePath(subPath string) string {
 	fullPath := pathutil.GetDataPath(subPath)
-	if !strings.HasPrefix(fullPath, filepath.Join(pathutil.GetRootDir(), pathutil.DATA_DIR)) {
+	if !strings.HasPrefix(fullPath, pathutil.GetDataDir()) {
 		return ""
 	}
 	return filepath.Clean(fullPath)
@@ -56,7 +56,7 @@ func HandlerFileList(c *gin.Context) {
 			continue
 		}
 		
-		relativePath, err := filepath.Rel(filepath.Join(pathutil.GetRootDir(), pathutil.DATA_DIR), filepath.Join(fullPath, f.Name()))
+		relativePath, err := filepath.Rel(pathutil.GetDataDir(), filepath.Join(fullPath, f.Name()))
 		if err != nil {
 			continue
 		}
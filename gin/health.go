@@ -0,0 +1,74 @@
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 存活检查使用的连接,web服务开始监听后设置
+var (
+	healthURL    string
+	healthClient *http.Client
+)
+
+// setHealthTarget 根据实际的监听地址创建存活检查的连接,调用方需持有 serverLock
+func (p *ApiData) setHealthTarget(ln net.Listener) {
+	transport := &http.Transport{DisableKeepAlives: true}
+	scheme, host := "http", "localhost"
+	switch addr := ln.Addr().(type) {
+	case *net.UnixAddr:
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr.Name)
+		}
+	case *net.TCPAddr:
+		ip := addr.IP
+		// 监听所有地址时通过本机回环地址连接
+		if ip.IsUnspecified() {
+			ip = net.IPv6loopback
+			if addr.IP.To4() != nil {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+		}
+		host = net.JoinHostPort(ip.String(), fmt.Sprint(addr.Port))
+	}
+	if p.TLS.Enable {
+		scheme = "https"
+		// 只连接本进程的监听,不需要校验证书
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	healthURL = scheme + "://" + host
+	healthClient = &http.Client{Transport: transport}
+}
+
+// Alive 通过监听地址发送一次 OPTIONS * 请求,检查web服务仍在接受和处理连接。
+// 该请求由http.Server直接响应,不经过路由,也不记录访问日志
+func Alive(timeout time.Duration) error {
+	serverLock.Lock()
+	url, client := healthURL, healthClient
+	serverLock.Unlock()
+	if client == nil {
+		return fmt.Errorf("web服务未监听")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, url, nil)
+	if err != nil {
+		return err
+	}
+	req.URL.Opaque = "*"
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("web服务无响应: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("web服务响应异常: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package serve
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+var (
+	installToken string     // 安装令牌,只输出在控制台和系统日志中,防止他人抢先安装
+	installLock  sync.Mutex // 防止并发安装
+)
+
+// prepareInstall 未安装时生成安装令牌并提示
+func prepareInstall() {
+	if config.IsInstalled() {
+		return
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("生成安装令牌失败: %v", err)
+		return
+	}
+	installToken = hex.EncodeToString(buf)
+	fmt.Println("程序未安装,请在面板中完成初始化,安装令牌：" + installToken)
+	log.Println("程序未安装,安装令牌：" + installToken)
+}
+
+// HandlerInstallStatus 获取安装状态
+func (p *ApiData) HandlerInstallStatus(c *gin.Context) {
+	r.OkData(c, gin.H{
+		"installed": config.IsInstalled(),
+		"version":   config.Version,
+	})
+}
+
+// HandlerInstallSetup 首次运行时设置管理员账号、端口和数据选项
+func (p *ApiData) HandlerInstallSetup(c *gin.Context) {
+	var req struct {
+		Token            string `json:"token"`              // 安装令牌
+		Username         string `json:"username"`           // 管理员用户名
+		Password         string `json:"password"`           // 密码(SHA256)
+		Port             string `json:"port"`               // 端口
+		CookieExpireDays int    `json:"cookie_expire_days"` // Cookie过期天数
+		LogCleanDays     int    `json:"log_clean_days"`     // 日志清理天数
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	installLock.Lock()
+	defer installLock.Unlock()
+
+	if config.IsInstalled() {
+		r.ErrMesage(c, "程序已安装")
+		return
+	}
+	if installToken == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(installToken)) != 1 {
+		log.Printf("安装令牌错误[ip=%s]", c.ClientIP())
+		r.ErrMesage(c, "安装令牌错误")
+		return
+	}
+	if req.Username == "" {
+		r.ErrMesage(c, "用户名不能为空")
+		return
+	}
+	if !lib.IsLegacyHash(req.Password) {
+		r.ErrMesage(c, "密码格式错误")
+		return
+	}
+	if req.Port == "" {
+		req.Port = p.Port
+	}
+	if port, err := strconv.Atoi(req.Port); err != nil || port <= 0 || port > 65535 {
+		r.ErrMesage(c, "端口格式错误")
+		return
+	}
+	if req.CookieExpireDays <= 0 {
+		req.CookieExpireDays = 30
+	}
+	if req.LogCleanDays <= 0 {
+		req.LogCleanDays = 7
+	}
+
+	hash, err := lib.HashPassword(req.Password)
+	if err != nil {
+		r.ErrMesage(c, "密码加密失败")
+		return
+	}
+
+	jsonStr := config.DefaultConfig().Raw
+	jsonStr, _ = sjson.Set(jsonStr, "username", req.Username)
+	jsonStr, _ = sjson.Set(jsonStr, "password", hash)
+	jsonStr, _ = sjson.Set(jsonStr, "port", req.Port)
+	jsonStr, _ = sjson.Set(jsonStr, "cookie_expire_days", req.CookieExpireDays)
+	jsonStr, _ = sjson.Set(jsonStr, "log_clean_days", req.LogCleanDays)
+
+	if err := config.WriteConfigFile(config.ConfigPath(), []byte(jsonStr), config.Change{User: req.Username, Reason: "初始化安装"}); err != nil {
+		r.ErrMesage(c, "配置文件写入失败: "+err.Error())
+		return
+	}
+	installToken = ""
+
+	// 刷新缓存的配置
+	InitGlobalConfig()
+	xuanwu.UpdateLogCleanDays(req.LogCleanDays)
+	log.Printf("安装完成,管理员: %s [ip=%s]", req.Username, c.ClientIP())
+
+	msg := "安装成功"
+	if req.Port != p.Port {
+		msg = "安装成功,端口修改将在重启后生效"
+	}
+	r.OkMesage(c, msg)
+}
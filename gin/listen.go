@@ -0,0 +1,104 @@
+package serve
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const unixPrefix = "unix:"
+
+// ListenOptions 监听地址、反向代理路径和可信代理配置
+type ListenOptions struct {
+	Listen         string   // 监听地址,为空表示所有地址,支持主机名、IPv6和 unix:/path/to.sock
+	BasePath       string   // 反向代理路径前缀,如 /xuanwu
+	TrustedProxies []string // 可信代理IP或网段,只信任来自这些地址的 X-Forwarded-For
+}
+
+// ParseListenOptions 从配置中解析监听配置
+func ParseListenOptions(cfg gjson.Result) (*ListenOptions, error) {
+	opts := &ListenOptions{
+		Listen:   strings.TrimSpace(cfg.Get("listen").String()),
+		BasePath: normalizeBasePath(cfg.Get("base_path").String()),
+	}
+	for _, v := range cfg.Get("trusted_proxies").Array() {
+		if s := strings.TrimSpace(v.String()); s != "" {
+			opts.TrustedProxies = append(opts.TrustedProxies, s)
+		}
+	}
+	if opts.IsUnix() && opts.socketPath() == "" {
+		return nil, fmt.Errorf("unix socket路径不能为空")
+	}
+	if !opts.IsUnix() && strings.ContainsAny(opts.Listen, "/ ") {
+		return nil, fmt.Errorf("监听地址格式错误: %s", opts.Listen)
+	}
+	return opts, nil
+}
+
+// normalizeBasePath 统一为 /xxx 格式,根路径返回空字符串
+func normalizeBasePath(base string) string {
+	base = strings.Trim(strings.TrimSpace(base), "/")
+	if base == "" {
+		return ""
+	}
+	return "/" + base
+}
+
+// IsUnix 是否监听unix socket
+func (o *ListenOptions) IsUnix() bool {
+	return strings.HasPrefix(o.Listen, unixPrefix)
+}
+
+// socketPath unix socket文件路径,相对路径放在数据目录下
+func (o *ListenOptions) socketPath() string {
+	path := strings.TrimPrefix(o.Listen, unixPrefix)
+	if path != "" && !filepath.IsAbs(path) {
+		path = pathutil.GetDataPath(path)
+	}
+	return path
+}
+
+// Addr 用于显示的监听地址
+func (o *ListenOptions) Addr(port string) string {
+	if o.IsUnix() {
+		return unixPrefix + o.socketPath()
+	}
+	return net.JoinHostPort(strings.Trim(o.Listen, "[]"), port)
+}
+
+// listen 创建监听
+func (o *ListenOptions) listen(port string) (net.Listener, error) {
+	if !o.IsUnix() {
+		return net.Listen("tcp", o.Addr(port))
+	}
+	path := o.socketPath()
+	if err := pathutil.EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	// 清理上次异常退出残留的socket文件
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0660)
+	return ln, nil
+}
+
+// unixRemoteAddr unix socket连接没有客户端地址,视为本机连接,以便按可信代理解析真实IP
+func unixRemoteAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, _, err := net.SplitHostPort(req.RemoteAddr); err != nil {
+			req.RemoteAddr = "127.0.0.1:0"
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,393 @@
+package serve
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	r "xuanwu/gin/response"
+	xwlog "xuanwu/log"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultFetchBytes = 64 * 1024   // 按范围读取日志的默认字节数
+	maxFetchBytes     = 1024 * 1024 // 按范围读取日志的最大字节数
+	defaultTailLines  = 100
+	maxTailLines      = 10000
+)
+
+// HandlerLogList 日志文件和各任务的执行日志汇总
+func (p *ApiData) HandlerLogList(c *gin.Context) {
+	files, err := xwlog.ListLogFiles()
+	if err != nil {
+		r.ErrMesage(c, "读取日志目录失败")
+		return
+	}
+	tasks, err := xwlog.ListTaskRuns()
+	if err != nil {
+		r.ErrMesage(c, "读取执行日志失败")
+		return
+	}
+	r.OkData(c, gin.H{
+		"files":     files,
+		"tasks":     tasks,
+		"retention": xwlog.GetRunRetention(),
+		"output":    xuanwu.GetOutputLimit(),
+	})
+}
+
+// HandlerLogRuns 任务的执行记录,按时间从新到旧
+func (p *ApiData) HandlerLogRuns(c *gin.Context) {
+	runs, err := xwlog.ListRuns(c.Query("task"))
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	r.OkData(c, runs)
+}
+
+// logSource 根据参数确定要读取的日志: task和id为某次执行,只有task时为最近一次执行,file为日志目录下的文件。
+// 读取执行日志时run不为nil
+func logSource(c *gin.Context) (path string, run *xwlog.RunInfo, err error) {
+	if file := c.Query("file"); file != "" {
+		path, err = xwlog.LogFilePath(file)
+		return path, nil, err
+	}
+	task, id := c.Query("task"), c.Query("id")
+	if task == "" {
+		return "", nil, fmt.Errorf("缺少task或file参数")
+	}
+	if id == "" {
+		runs, err := xwlog.ListRuns(task)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(runs) == 0 {
+			return "", nil, fmt.Errorf("任务[%s]没有执行日志", task)
+		}
+		id = runs[0].ID
+	}
+	info, err := xwlog.GetRun(task, id)
+	if err != nil {
+		return "", nil, err
+	}
+	path, err = xwlog.RunLogPath(task, id)
+	return path, &info, err
+}
+
+// logOutputOptions 执行日志的输出格式和输出流参数
+// format: text(去掉转义序列的纯文本,默认) lines(每行的输出流和时间,带颜色的行同时返回分段样式) html(颜色转换为带样式的span) raw(存储格式);
+// stream: stdout stderr system
+func logOutputOptions(c *gin.Context) (format string, stream xwlog.Stream, err error) {
+	format = c.DefaultQuery("format", "text")
+	if format != "text" && format != "lines" && format != "html" && format != "raw" {
+		return "", 0, fmt.Errorf("format参数错误")
+	}
+	stream, ok := xwlog.ParseStream(c.Query("stream"))
+	if !ok {
+		return "", 0, fmt.Errorf("stream参数错误")
+	}
+	if format == "raw" && stream != 0 {
+		return "", 0, fmt.Errorf("raw格式不支持按输出流筛选")
+	}
+	return format, stream, nil
+}
+
+// HandlerLogFetch 按字节范围读取日志,参数 offset(负数表示从末尾倒数) limit,执行日志另有 format stream 参数。
+// offset和next为文件中的位置,执行日志只返回完整的行
+func (p *ApiData) HandlerLogFetch(c *gin.Context) {
+	path, run, err := logSource(c)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	format, stream, err := logOutputOptions(c)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		r.ErrMesage(c, "offset参数错误")
+		return
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultFetchBytes)), 10, 64)
+	if err != nil || limit <= 0 {
+		r.ErrMesage(c, "limit参数错误")
+		return
+	}
+	if limit > maxFetchBytes {
+		limit = maxFetchBytes
+	}
+
+	if run == nil || format == "raw" {
+		chunk, err := xwlog.ReadRange(path, offset, limit)
+		if err != nil {
+			r.ErrMesage(c, err.Error())
+			return
+		}
+		r.OkData(c, chunk)
+		return
+	}
+	chunk, err := xwlog.ReadLines(path, offset, limit, stream, run.Start)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	if format == "lines" {
+		xwlog.ParseColors(chunk.Lines)
+		r.OkData(c, chunk)
+		return
+	}
+	content := xwlog.LinesText(chunk.Lines)
+	if format == "html" {
+		content = xwlog.LinesHTML(chunk.Lines)
+	}
+	r.OkData(c, xwlog.Chunk{
+		Content: content,
+		Offset:  chunk.Offset,
+		Next:    chunk.Next,
+		Size:    chunk.Size,
+		EOF:     chunk.EOF,
+	})
+}
+
+// HandlerLogTail 读取日志最后的几行,参数 lines,执行日志另有 format stream 参数
+func (p *ApiData) HandlerLogTail(c *gin.Context) {
+	path, run, err := logSource(c)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	if strings.HasSuffix(path, ".gz") {
+		r.ErrMesage(c, "压缩的日志文件请下载后查看")
+		return
+	}
+	format, stream, err := logOutputOptions(c)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", strconv.Itoa(defaultTailLines)))
+	if err != nil || lines <= 0 {
+		r.ErrMesage(c, "lines参数错误")
+		return
+	}
+	if lines > maxTailLines {
+		lines = maxTailLines
+	}
+
+	// next 可以作为按范围读取的 offset 继续读取新增内容
+	if run != nil && format != "raw" {
+		list, next, err := xwlog.TailRunLines(path, lines, stream, run.Start)
+		if err != nil {
+			r.ErrMesage(c, "读取日志失败")
+			return
+		}
+		switch format {
+		case "lines":
+			xwlog.ParseColors(list)
+			r.OkData(c, gin.H{"lines": list, "next": next})
+		case "html":
+			r.OkData(c, gin.H{"content": xwlog.LinesHTML(list), "next": next})
+		default:
+			r.OkData(c, gin.H{"content": xwlog.LinesText(list), "next": next})
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		r.ErrMesage(c, "读取日志失败")
+		return
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	next, err := xwlog.TailLines(f, lines, &buf)
+	if err != nil {
+		r.ErrMesage(c, "读取日志失败")
+		return
+	}
+	r.OkData(c, gin.H{
+		"content": buf.String(),
+		"next":    next,
+	})
+}
+
+// HandlerLogSearch 在执行日志中搜索
+// 参数 q regex=1 case=1(区分大小写) task(可重复或用逗号分隔) stream start end limit,时间格式同审计日志
+func (p *ApiData) HandlerLogSearch(c *gin.Context) {
+	q := xwlog.SearchQuery{
+		Pattern:    c.Query("q"),
+		Regex:      c.Query("regex") == "1" || c.Query("regex") == "true",
+		IgnoreCase: c.Query("case") != "1" && c.Query("case") != "true",
+	}
+	var ok bool
+	if q.Stream, ok = xwlog.ParseStream(c.Query("stream")); !ok {
+		r.ErrMesage(c, "stream参数错误")
+		return
+	}
+	for _, v := range c.QueryArray("task") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				q.Tasks = append(q.Tasks, name)
+			}
+		}
+	}
+	q.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	if q.Start, ok = parseQueryTime(c.Query("start"), false); !ok {
+		r.ErrMesage(c, "开始时间格式错误")
+		return
+	}
+	if q.End, ok = parseQueryTime(c.Query("end"), true); !ok {
+		r.ErrMesage(c, "结束时间格式错误")
+		return
+	}
+
+	matches, truncated, err := xwlog.SearchRuns(q)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	r.OkData(c, gin.H{
+		"list":      matches,
+		"truncated": truncated,
+	})
+}
+
+// zipEntry 压缩包中的文件
+type zipEntry struct {
+	name string // 压缩包内的路径
+	path string
+}
+
+// HandlerLogDownload 将选中的日志打包为zip下载
+// 参数 file(日志目录下的文件) run(任务名称/执行ID) task(任务的所有执行),均可重复
+func (p *ApiData) HandlerLogDownload(c *gin.Context) {
+	var entries []zipEntry
+	added := map[string]bool{}
+	add := func(name, path string) {
+		if !added[name] {
+			added[name] = true
+			entries = append(entries, zipEntry{name: name, path: path})
+		}
+	}
+	addRun := func(task, id string) error {
+		path, err := xwlog.RunLogPath(task, id)
+		if err != nil {
+			return err
+		}
+		add(filepath.ToSlash(filepath.Join(xwlog.RUNS_DIR, task, id+".log")), path)
+		// 同时打包执行信息
+		meta := strings.TrimSuffix(path, ".log") + ".json"
+		if _, err := os.Stat(meta); err == nil {
+			add(filepath.ToSlash(filepath.Join(xwlog.RUNS_DIR, task, id+".json")), meta)
+		}
+		return nil
+	}
+
+	for _, name := range c.QueryArray("file") {
+		path, err := xwlog.LogFilePath(name)
+		if err != nil {
+			r.ErrMesage(c, err.Error())
+			return
+		}
+		add(name, path)
+	}
+	for _, v := range c.QueryArray("run") {
+		task, id, found := strings.Cut(v, "/")
+		if !found {
+			r.ErrMesage(c, "run参数格式为 任务名称/执行ID")
+			return
+		}
+		if err := addRun(task, id); err != nil {
+			r.ErrMesage(c, err.Error())
+			return
+		}
+	}
+	for _, task := range c.QueryArray("task") {
+		runs, err := xwlog.ListRuns(task)
+		if err != nil {
+			r.ErrMesage(c, err.Error())
+			return
+		}
+		for _, run := range runs {
+			addRun(task, run.ID)
+		}
+	}
+	if len(entries) == 0 {
+		r.ErrMesage(c, "没有选择要下载的日志")
+		return
+	}
+
+	fileName := fmt.Sprintf("logs-%s.zip", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	zw := zip.NewWriter(c.Writer)
+	for _, entry := range entries {
+		if err := addZipFile(zw, entry); err != nil {
+			// 响应已开始发送,只能中断
+			xwlog.Warnf("打包日志失败[%s]: %v", entry.name, err)
+			c.Abort()
+			return
+		}
+	}
+	zw.Close()
+}
+
+// addZipFile 将文件写入压缩包
+func addZipFile(zw *zip.Writer, entry zipEntry) error {
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	header.Method = zip.Deflate
+	// 已压缩的轮转日志不再压缩
+	if strings.HasSuffix(entry.name, ".gz") {
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// HandlerLogCleanupStatus 最近一次日志清理的结果
+func (p *ApiData) HandlerLogCleanupStatus(c *gin.Context) {
+	r.OkData(c, gin.H{
+		"days":      xuanwu.GetLogCleanDays(),
+		"retention": xwlog.GetRunRetention(),
+		"last":      xwlog.LastCleanup(),
+	})
+}
+
+// HandlerLogCleanup 立即清理日志
+func (p *ApiData) HandlerLogCleanup(c *gin.Context) {
+	report, err := xuanwu.CleanLogsNow()
+	if err != nil {
+		r.ErrMesage(c, "清理日志失败: "+err.Error())
+		return
+	}
+	r.OkMesageData(c, fmt.Sprintf("已清理%d个文件,释放%d字节", len(report.Files), report.Freed), report)
+}
@@ -1,11 +1,14 @@
 package serve
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
+	"sync"
 	"xuanwu/gin/cron"
+	xwlog "xuanwu/log"
 	"xuanwu/static"
 
 	"github.com/gin-gonic/gin"
@@ -18,9 +21,60 @@ type ApiData struct {
 	RootRoute *gin.Engine
 	AddApi    map[string]string
 	Port      string
+	TLS       *TLSOptions    // HTTPS配置
+	Listen    *ListenOptions // 监听地址及反向代理配置
+	Server    *http.Server   // web服务
+	OnListen  func(error)    // 开始监听或启动失败后调用,可以为空
 }
 
-func InitApi(cfg gjson.Result, addApi map[string]string) {
+// 当前运行的web服务,用于关闭
+var (
+	currentServer *http.Server
+	serverLock    sync.Mutex
+)
+
+// Shutdown 关闭web服务,不再接受新请求,并在ctx截止前等待处理中的请求完成
+func Shutdown(ctx context.Context) error {
+	serverLock.Lock()
+	srv := currentServer
+	adminSrv := currentAdminServer
+	redirectSrv := currentRedirectServer
+	serverLock.Unlock()
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(ctx)
+	}
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// InitApi 启动web服务,开始监听或启动失败后调用 onListen
+func InitApi(cfg gjson.Result, addApi map[string]string, onListen func(error)) {
+	ApiData, err := newApiData(cfg, addApi)
+	if err != nil {
+		log.Printf("%v,web服务停止", err)
+		if onListen != nil {
+			onListen(err)
+		}
+		return
+	}
+	ApiData.OnListen = onListen
+	ApiData.Init()
+}
+
+// listened 通知监听结果
+func (p *ApiData) listened(err error) {
+	if p.OnListen != nil {
+		p.OnListen(err)
+	}
+}
+
+// newApiData 从配置中解析端口、HTTPS和监听配置
+func newApiData(cfg gjson.Result, addApi map[string]string) (*ApiData, error) {
 	ApiData := &ApiData{
 		Cookie: "", //刷新token
 		Port:   "4165",
@@ -29,56 +83,171 @@ func InitApi(cfg gjson.Result, addApi map[string]string) {
 	if cfg.Get("port").String() != "" {
 		ApiData.Port = cfg.Get("port").String()
 	}
-	ApiData.Init()
+	tlsOpts, err := ParseTLSOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("HTTPS配置错误: %v", err)
+	}
+	ApiData.TLS = tlsOpts
+	listenOpts, err := ParseListenOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("监听配置错误: %v", err)
+	}
+	ApiData.Listen = listenOpts
+	return ApiData, nil
 }
 
 func (p *ApiData) Init() {
+	// 未安装时生成安装令牌
+	prepareInstall()
 
+	RootRoute, err := p.Router()
+	if err != nil {
+		log.Printf("%v,web服务停止", err)
+		p.listened(err)
+		return
+	}
+
+	p.Server = &http.Server{
+		Handler: RootRoute,
+	}
+	if p.Listen.IsUnix() {
+		p.Server.Handler = unixRemoteAddr(RootRoute)
+	}
+	serverLock.Lock()
+	currentServer = p.Server
+	serverLock.Unlock()
+	ln, err := p.Listen.listen(p.Port)
+	if err != nil {
+		log.Printf("web服务监听失败: %v", err)
+		p.listened(err)
+		return
+	}
+	// 本机管理命令使用的socket
+	go p.startAdminServer(RootRoute)
+
+	addr := p.Listen.Addr(p.Port) + p.Listen.BasePath
+	if p.TLS.Enable {
+		tlsCfg, err := p.tlsConfig()
+		if err != nil {
+			ln.Close()
+			log.Printf("HTTPS证书加载失败,web服务停止: %v", err)
+			p.listened(err)
+			return
+		}
+		p.Server.TLSConfig = tlsCfg
+		if p.TLS.RedirectHttp && !p.Listen.IsUnix() {
+			go p.startRedirectServer()
+		}
+	}
+	serverLock.Lock()
+	p.setHealthTarget(ln)
+	serverLock.Unlock()
+	p.listened(nil)
+
+	if p.TLS.Enable {
+		fmt.Println("Web 地址(HTTPS)：" + addr)
+		err = p.Server.ServeTLS(ln, "", "")
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("web服务启动失败: %v", err)
+		}
+		return
+	}
+
+	fmt.Println("Web 地址：" + addr)
+	if err := p.Server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		log.Printf("web服务启动失败: %v", err)
+	}
+}
+
+// Router 创建gin引擎并注册所有接口
+func (p *ApiData) Router() (*gin.Engine, error) {
 	gin.SetMode(gin.ReleaseMode) // 关闭gin启动时路由打印
-	RootRoute := gin.Default()
+	// gin的输出写入系统日志,访问日志由AccessLog按配置的格式记录
+	ginLog := xwlog.Component("gin")
+	gin.DefaultWriter = ginLog.Writer(xwlog.LevelDebug)
+	gin.DefaultErrorWriter = ginLog.Writer(xwlog.LevelError)
+	RootRoute := gin.New()
+	RootRoute.Use(gin.RecoveryWithWriter(gin.DefaultErrorWriter), p.AccessLog())
 	p.RootRoute = RootRoute
+	// 只信任配置中的代理转发的客户端IP,未配置时不信任任何代理
+	if err := RootRoute.SetTrustedProxies(p.Listen.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("可信代理配置错误: %v", err)
+	}
 	RootRoute.Use(p.CookieHandler()) //全局用户认证
 
-	routeApi := RootRoute.Group("/api") // api接口总路由
+	routeBase := RootRoute.Group(p.Listen.BasePath)
+	routeApi := routeBase.Group("/api") // api接口总路由
 	filesys, err := static.StaticFS()
 	if err != nil {
-		log.Println("加载后台文件失败,web服务停止")
-		return
+		return nil, fmt.Errorf("加载后台文件失败")
 	}
-	RootRoute.StaticFS("/xwui", http.FS(filesys))
+	routeBase.StaticFS("/xwui", http.FS(filesys))
+
+	// 安装接口
+	routeInstall := routeApi.Group("/install")
+	routeInstall.GET("/status", p.HandlerInstallStatus) // 获取安装状态
+	routeInstall.POST("/setup", p.Audit("install.setup"), p.HandlerInstallSetup)  // 首次运行初始化
 
 	// 管理接口
 	routeAdmin := routeApi.Group("/user")
 	routeAdmin.GET("/profile", p.HandlerGetUserProfile)    // 获取用户配置
-	routeAdmin.POST("/profile", p.HandlerUpdateUserProfile) // 更新用户配置
+	routeAdmin.POST("/profile", p.Audit("user.profile"), p.HandlerUpdateUserProfile) // 更新用户配置
 
 	// 登录接口
 	routeAuth := routeApi.Group("/auth")
-	routeAuth.POST("/login", p.LoginHandle)
-	routeAuth.GET("/logout", p.LogoutHandler)
+	routeAuth.POST("/login", p.Audit("auth.login"), p.LoginHandle)
+	routeAuth.POST("/login/2fa", p.Audit("auth.login_2fa"), p.HandlerLoginTwoFactor) // 登录第二步:两步验证
+	routeAuth.GET("/logout", p.Audit("auth.logout"), p.LogoutHandler)
+	routeAuth.GET("/lockouts", p.HandlerLockoutList)        // 登录失败及锁定记录
+	routeAuth.POST("/lockouts/clear", p.Audit("auth.lockout_clear"), p.HandlerLockoutClear) // 清除登录锁定
+	routeAuth.GET("/2fa/status", p.HandlerTwoFactorStatus)   // 两步验证状态
+	routeAuth.POST("/2fa/setup", p.Audit("auth.2fa_setup"), p.HandlerTwoFactorSetup)    // 生成两步验证密钥
+	routeAuth.POST("/2fa/enable", p.Audit("auth.2fa_enable"), p.HandlerTwoFactorEnable)  // 校验验证码并启用
+	routeAuth.POST("/2fa/disable", p.Audit("auth.2fa_disable"), p.HandlerTwoFactorDisable) // 关闭两步验证
 
 	// 定时任务接口
 	routeCron := routeApi.Group("/cron")
 	/* 任务源 */
 	routeCron.GET("/list", cron.HandlerTaskList)    //获取任务列表（包含运行状态）
-	routeCron.GET("/delete", cron.HandlerDeleteTask)   //删除源任务
-	routeCron.POST("/add", cron.HandlerAddTask)        //添加任务源
-	routeCron.POST("/update", cron.HandlerAddTask)     //更新任务（复用添加接口）
+	routeCron.GET("/delete", p.Audit("task.delete"), cron.HandlerDeleteTask)   //删除源任务
+	routeCron.POST("/add", p.Audit("task.add"), cron.HandlerAddTask)        //添加任务源
+	routeCron.POST("/update", p.Audit("task.update"), cron.HandlerAddTask)     //更新任务（复用添加接口）
 	/* 任务控制 */
-	routeCron.GET("/enable", cron.HandlerEnableTask)   //启用任务
-	routeCron.GET("/disable", cron.HandlerDisableTask) //禁用任务
-	routeCron.POST("/execute", cron.HandlerExecuteTask) //立即执行任务
+	routeCron.GET("/enable", p.Audit("task.enable"), cron.HandlerEnableTask)   //启用任务
+	routeCron.GET("/disable", p.Audit("task.disable"), cron.HandlerDisableTask) //禁用任务
+	routeCron.POST("/execute", p.Audit("task.execute"), cron.HandlerExecuteTask) //立即执行任务
 
 	// 文件管理接口
 	routeFile := routeApi.Group("/file")
 	routeFile.GET("/list", HandlerFileList)       // 获取文件列表
-	routeFile.POST("/upload", HandlerFileUpload)  // 上传文件
-	routeFile.POST("/batch-upload", HandlerBatchUpload) // 批量上传文件
-	routeFile.POST("/mkdir", HandlerMkdir)       // 创建文件夹
+	routeFile.POST("/upload", p.Audit("file.upload"), HandlerFileUpload)  // 上传文件
+	routeFile.POST("/batch-upload", p.Audit("file.upload"), HandlerBatchUpload) // 批量上传文件
+	routeFile.POST("/mkdir", p.Audit("file.mkdir"), HandlerMkdir)       // 创建文件夹
 	routeFile.GET("/download", HandlerFileDownload) // 下载文件
 	routeFile.GET("/content", HandlerFileContent) // 获取文件内容
-	routeFile.POST("/edit", HandlerFileEdit)     // 编辑文件
-	routeFile.GET("/delete", HandlerFileDelete)  // 删除文件
+	routeFile.POST("/edit", p.Audit("file.edit"), HandlerFileEdit)     // 编辑文件
+	routeFile.GET("/delete", p.Audit("file.delete"), HandlerFileDelete)  // 删除文件
+
+	// 配置接口
+	routeConfig := routeApi.Group("/config")
+	routeConfig.POST("/reload", p.Audit("config.reload"), p.HandlerConfigReload) // 重新加载配置文件
+	routeConfig.GET("/history", p.HandlerConfigHistory)                          // 配置历史版本
+	routeConfig.GET("/history/diff", p.HandlerConfigHistoryDiff)                 // 对比配置版本
+	routeConfig.POST("/history/rollback", p.Audit("config.rollback"), p.HandlerConfigRollback) // 回滚配置
+
+	// 日志接口
+	routeLog := routeApi.Group("/log")
+	routeLog.GET("/list", p.HandlerLogList)                                 // 日志文件和执行日志汇总
+	routeLog.GET("/runs", p.HandlerLogRuns)                                 // 任务的执行记录
+	routeLog.GET("/fetch", p.HandlerLogFetch)                               // 按字节范围读取日志
+	routeLog.GET("/tail", p.HandlerLogTail)                                 // 日志最后几行
+	routeLog.GET("/search", p.HandlerLogSearch)                             // 搜索执行日志
+	routeLog.GET("/download", p.Audit("log.download"), p.HandlerLogDownload) // 打包下载日志
+	routeLog.GET("/cleanup", p.HandlerLogCleanupStatus)                     // 最近一次日志清理的结果
+	routeLog.POST("/cleanup", p.Audit("log.cleanup"), p.HandlerLogCleanup)  // 立即清理日志
+
+	// 审计日志接口
+	routeApi.GET("/audit", p.HandlerAuditList) // 分页查询审计日志
 
 	// 关键点【解决页面刷新404的问题】
 	RootRoute.NoRoute(func(c *gin.Context) {
@@ -93,6 +262,5 @@ func (p *ApiData) Init() {
 		c.Writer.Flush()
 	})
 
-	fmt.Println("Web 端口：" + p.Port)
-	RootRoute.Run(":" + p.Port)
+	return RootRoute, nil
 }
@@ -0,0 +1,222 @@
+package serve
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"xuanwu/lib"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	CERT_DIR          = "certs"
+	selfSignedValid   = 365 * 24 * time.Hour // 自签名证书有效期
+	selfSignedRenew   = 30 * 24 * time.Hour  // 剩余有效期不足时自动续期
+	certCheckInterval = time.Minute          // 检查证书变化的间隔
+)
+
+// TLSOptions HTTPS配置,对应config.json中的tls字段
+type TLSOptions struct {
+	Enable       bool   // 是否启用HTTPS
+	Cert         string // 证书路径,为空时使用自签名证书
+	Key          string // 私钥路径
+	MinVersion   uint16 // 最低TLS版本
+	RedirectHttp bool   // 是否开启HTTP跳转HTTPS
+	HttpPort     string // HTTP跳转监听端口
+}
+
+// 支持的最低TLS版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSOptions 从配置中解析HTTPS配置
+func ParseTLSOptions(cfg gjson.Result) (*TLSOptions, error) {
+	t := cfg.Get("tls")
+	opts := &TLSOptions{
+		Enable:       t.Get("enable").Bool(),
+		Cert:         t.Get("cert").String(),
+		Key:          t.Get("key").String(),
+		MinVersion:   tls.VersionTLS12,
+		RedirectHttp: t.Get("redirect_http").Bool(),
+		HttpPort:     t.Get("http_port").String(),
+	}
+	if v := t.Get("min_version").String(); v != "" {
+		ver, ok := tlsVersions[v]
+		if !ok {
+			return nil, fmt.Errorf("不支持的TLS版本: %s", v)
+		}
+		opts.MinVersion = ver
+	}
+	if (opts.Cert == "") != (opts.Key == "") {
+		return nil, fmt.Errorf("证书和私钥路径需要同时设置")
+	}
+	if opts.HttpPort == "" {
+		opts.HttpPort = "80"
+	}
+	return opts, nil
+}
+
+// certManager 负责加载证书,并在证书文件变化或自签名证书即将过期时重新加载
+type certManager struct {
+	certPath   string
+	keyPath    string
+	selfSigned bool
+	hosts      []string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	notAfter  time.Time
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// newCertManager 创建证书管理器,未指定证书时在数据目录生成自签名证书
+func newCertManager(opts *TLSOptions) (*certManager, error) {
+	m := &certManager{
+		certPath: opts.Cert,
+		keyPath:  opts.Key,
+	}
+	if m.certPath == "" {
+		m.selfSigned = true
+		m.certPath = pathutil.GetDataPath(filepath.Join(CERT_DIR, "selfsigned.crt"))
+		m.keyPath = pathutil.GetDataPath(filepath.Join(CERT_DIR, "selfsigned.key"))
+		m.hosts = localHosts()
+	} else {
+		if !filepath.IsAbs(m.certPath) {
+			m.certPath = pathutil.GetDataPath(m.certPath)
+		}
+		if !filepath.IsAbs(m.keyPath) {
+			m.keyPath = pathutil.GetDataPath(m.keyPath)
+		}
+	}
+	if err := m.reload(true); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// localHosts 自签名证书中包含的主机名和本机IP
+func localHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+// reload 检查并重新加载证书,调用方需持有锁或处于初始化阶段
+func (m *certManager) reload(force bool) error {
+	now := time.Now()
+	if !force && now.Sub(m.lastCheck) < certCheckInterval {
+		return nil
+	}
+	m.lastCheck = now
+
+	// 自签名证书不存在或即将过期时重新生成
+	if m.selfSigned && (!pathutil.IsFileExist(m.certPath) || !pathutil.IsFileExist(m.keyPath) ||
+		(!m.notAfter.IsZero() && m.notAfter.Sub(now) < selfSignedRenew)) {
+		if err := lib.GenerateSelfSignedCert(m.certPath, m.keyPath, m.hosts, selfSignedValid); err != nil {
+			return fmt.Errorf("生成自签名证书失败: %v", err)
+		}
+		log.Printf("已生成自签名证书: %s", m.certPath)
+	}
+
+	info, err := os.Stat(m.certPath)
+	if err != nil {
+		return fmt.Errorf("读取证书失败: %v", err)
+	}
+	if m.cert != nil && info.ModTime().Equal(m.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("解析证书失败: %v", err)
+	}
+	cert.Leaf = leaf
+
+	// 首次加载时检查自签名证书的有效期
+	if m.selfSigned && leaf.NotAfter.Sub(now) < selfSignedRenew {
+		m.notAfter = leaf.NotAfter
+		m.cert = nil
+		return m.reload(true)
+	}
+
+	m.cert = &cert
+	m.notAfter = leaf.NotAfter
+	m.modTime = info.ModTime()
+	log.Printf("已加载证书: %s, 有效期至 %s", m.certPath, leaf.NotAfter.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// GetCertificate 供tls.Config使用,每次握手时按间隔检查证书是否需要更新
+func (m *certManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.reload(false); err != nil {
+		log.Printf("证书更新失败,继续使用旧证书: %v", err)
+	}
+	return m.cert, nil
+}
+
+// tlsConfig 生成HTTPS服务使用的tls配置
+func (p *ApiData) tlsConfig() (*tls.Config, error) {
+	m, err := newCertManager(p.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     p.TLS.MinVersion,
+		GetCertificate: m.GetCertificate,
+	}, nil
+}
+
+// 当前运行的HTTP跳转服务,用于关闭
+var currentRedirectServer *http.Server
+
+// startRedirectServer 启动HTTP跳转HTTPS的监听
+func (p *ApiData) startRedirectServer() {
+	srv := &http.Server{
+		Addr: ":" + p.TLS.HttpPort,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			host := req.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			target := "https://" + net.JoinHostPort(host, p.Port) + req.URL.RequestURI()
+			http.Redirect(w, req, target, http.StatusMovedPermanently)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serverLock.Lock()
+	currentRedirectServer = srv
+	serverLock.Unlock()
+	fmt.Println("HTTP跳转端口：" + p.TLS.HttpPort)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP跳转服务启动失败: %v", err)
+	}
+}
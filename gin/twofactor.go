@@ -0,0 +1,344 @@
+package serve
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	totpIssuer          = "xuanwu"
+	recoveryCodeCount   = 10
+	loginTicketTTL      = 5 * time.Minute
+	loginTicketMaxTries = 5
+)
+
+var (
+	errRecoveryCode  = errors.New("恢复码错误")
+	errPendingSecret = errors.New("待启用的密钥已变化")
+)
+
+// loginTicket 密码校验通过后等待两步验证的登录凭据,只保存在内存中
+type loginTicket struct {
+	username string
+	expires  time.Time
+	tries    int
+}
+
+var (
+	loginTickets     = map[string]*loginTicket{}
+	loginTicketsLock sync.Mutex
+	lastTOTPCounter  uint64 // 最近一次使用的时间步,防止验证码重放
+)
+
+// IsTwoFactorEnabled 是否开启了两步验证
+func IsTwoFactorEnabled(cfg gjson.Result) bool {
+	return cfg.Get("totp.enabled").Bool() && cfg.Get("totp.secret").String() != ""
+}
+
+// newLoginTicket 创建两步验证凭据
+func newLoginTicket(username string) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(buf)
+
+	loginTicketsLock.Lock()
+	defer loginTicketsLock.Unlock()
+	now := time.Now()
+	for k, t := range loginTickets {
+		if now.After(t.expires) {
+			delete(loginTickets, k)
+		}
+	}
+	loginTickets[ticket] = &loginTicket{
+		username: username,
+		expires:  now.Add(loginTicketTTL),
+	}
+	return ticket, nil
+}
+
+// verifyTwoFactorCode 校验动态验证码或恢复码
+func verifyTwoFactorCode(cfg gjson.Result, code string) bool {
+	if counter, ok := lib.ValidateTOTP(cfg.Get("totp.secret").String(), code, time.Now()); ok {
+		loginTicketsLock.Lock()
+		defer loginTicketsLock.Unlock()
+		if counter <= lastTOTPCounter {
+			return false
+		}
+		lastTOTPCounter = counter
+		return true
+	}
+
+	// 尝试恢复码,使用后立即作废,在配置锁内删除保证同一恢复码只能使用一次
+	hashed := lib.SHA256(lib.NormalizeRecoveryCode(code))
+	remain := 0
+	err := config.UpdateConfig(config.Change{User: "system", Reason: "使用两步验证恢复码"}, func(cfg gjson.Result) (string, error) {
+		codes := cfg.Get("totp.recovery_codes").Array()
+		for i, h := range codes {
+			if h.String() == hashed {
+				remain = len(codes) - 1
+				return sjson.Delete(cfg.Raw, fmt.Sprintf("totp.recovery_codes.%d", i))
+			}
+		}
+		return "", errRecoveryCode
+	})
+	if err != nil {
+		if err != errRecoveryCode {
+			log.Printf("恢复码作废失败: %v", err)
+		}
+		return false
+	}
+	log.Printf("使用恢复码登录,剩余%d个", remain)
+	return true
+}
+
+// HandlerLoginTwoFactor 登录第二步,校验动态验证码或恢复码
+func (p *ApiData) HandlerLoginTwoFactor(c *gin.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+		Code   string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Ticket == "" || req.Code == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	loginTicketsLock.Lock()
+	t, ok := loginTickets[req.Ticket]
+	if ok && time.Now().After(t.expires) {
+		delete(loginTickets, req.Ticket)
+		ok = false
+	}
+	loginTicketsLock.Unlock()
+	if !ok {
+		r.ErrMesage(c, "验证已过期,请重新登录")
+		return
+	}
+
+	ip := c.ClientIP()
+	keys := []string{"ip:" + ip, "user:" + t.username}
+	limiter := lib.GetLoginLimiter()
+	if wait := limiter.Check(keys...); wait > 0 {
+		r.ErrMesage(c, fmt.Sprintf("登录尝试过于频繁,请%d秒后再试", int(wait.Seconds())+1))
+		return
+	}
+
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	if !verifyTwoFactorCode(cfg, req.Code) {
+		limiter.Fail(keys...)
+		log.Printf("两步验证失败[ip=%s user=%s]", ip, t.username)
+		loginTicketsLock.Lock()
+		t.tries++
+		if t.tries >= loginTicketMaxTries {
+			delete(loginTickets, req.Ticket)
+		}
+		loginTicketsLock.Unlock()
+		r.ErrMesage(c, "验证码错误")
+		return
+	}
+
+	loginTicketsLock.Lock()
+	delete(loginTickets, req.Ticket)
+	loginTicketsLock.Unlock()
+	limiter.Success(keys...)
+	p.issueToken(c, t.username)
+}
+
+// HandlerTwoFactorStatus 获取两步验证状态
+func (p *ApiData) HandlerTwoFactorStatus(c *gin.Context) {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	r.OkData(c, gin.H{
+		"enabled":        IsTwoFactorEnabled(cfg),
+		"recovery_count": len(cfg.Get("totp.recovery_codes").Array()),
+	})
+}
+
+// HandlerTwoFactorSetup 生成新的密钥,等待首次验证通过后才会启用
+func (p *ApiData) HandlerTwoFactorSetup(c *gin.Context) {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	if IsTwoFactorEnabled(cfg) {
+		r.ErrMesage(c, "两步验证已启用,请先关闭")
+		return
+	}
+
+	secret, err := lib.GenerateTOTPSecret()
+	if err != nil {
+		r.ErrMesage(c, "生成密钥失败")
+		return
+	}
+	change := config.Change{User: c.GetString("username"), Reason: "生成两步验证密钥"}
+	err = config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		return sjson.Set(cfg.Raw, "totp.pending_secret", secret)
+	})
+	if err != nil {
+		r.ErrMesage(c, "配置文件写入失败")
+		return
+	}
+
+	r.OkData(c, gin.H{
+		"secret": secret,
+		"uri":    lib.TOTPURI(totpIssuer, cfg.Get("username").String(), secret),
+	})
+}
+
+// HandlerTwoFactorEnable 校验首个验证码并启用两步验证,返回一次性恢复码
+func (p *ApiData) HandlerTwoFactorEnable(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	secret := cfg.Get("totp.pending_secret").String()
+	if secret == "" {
+		r.ErrMesage(c, "请先生成密钥")
+		return
+	}
+	counter, ok := lib.ValidateTOTP(secret, req.Code, time.Now())
+	if !ok {
+		r.ErrMesage(c, "验证码错误")
+		return
+	}
+
+	codes, err := lib.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		r.ErrMesage(c, "生成恢复码失败")
+		return
+	}
+	hashed := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashed = append(hashed, lib.SHA256(code))
+	}
+
+	change := config.Change{User: c.GetString("username"), Reason: "启用两步验证"}
+	err = config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		// 校验期间密钥被重新生成时不启用
+		if cfg.Get("totp.pending_secret").String() != secret {
+			return "", errPendingSecret
+		}
+		jsonStr, _ := sjson.Set(cfg.Raw, "totp.secret", secret)
+		jsonStr, _ = sjson.Set(jsonStr, "totp.enabled", true)
+		jsonStr, _ = sjson.Set(jsonStr, "totp.recovery_codes", hashed)
+		return sjson.Delete(jsonStr, "totp.pending_secret")
+	})
+	if err == errPendingSecret {
+		r.ErrMesage(c, "密钥已变化,请重新生成")
+		return
+	}
+	if err != nil {
+		r.ErrMesage(c, "配置文件写入失败")
+		return
+	}
+
+	loginTicketsLock.Lock()
+	lastTOTPCounter = counter
+	loginTicketsLock.Unlock()
+
+	log.Printf("两步验证已启用")
+	r.OkMesageData(c, "两步验证已启用,请妥善保存恢复码", gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// HandlerTwoFactorDisable 关闭两步验证,需要当前密码和验证码(或恢复码)
+func (p *ApiData) HandlerTwoFactorDisable(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" || req.Code == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	if !IsTwoFactorEnabled(cfg) {
+		r.ErrMesage(c, "两步验证未启用")
+		return
+	}
+
+	// 和登录共用限流,防止通过关闭接口猜测密码和验证码
+	ip := c.ClientIP()
+	username := c.GetString("username")
+	keys := []string{"ip:" + ip, "user:" + username}
+	limiter := lib.GetLoginLimiter()
+	if wait := limiter.Check(keys...); wait > 0 {
+		log.Printf("关闭两步验证被限制[ip=%s user=%s]: 需等待%v", ip, username, wait.Round(time.Second))
+		r.ErrMesage(c, fmt.Sprintf("尝试过于频繁,请%d秒后再试", int(wait.Seconds())+1))
+		return
+	}
+	if ok, _ := lib.CheckPassword(cfg.Get("password").String(), req.Password); !ok {
+		twoFactorDisableFailed(limiter, keys, ip, username)
+		r.ErrMesage(c, "密码错误")
+		return
+	}
+	if !verifyTwoFactorCode(cfg, req.Code) {
+		twoFactorDisableFailed(limiter, keys, ip, username)
+		r.ErrMesage(c, "验证码错误")
+		return
+	}
+	limiter.Success(keys...)
+
+	if err := DisableTwoFactor(config.Change{User: username, Reason: "关闭两步验证"}); err != nil {
+		r.ErrMesage(c, "配置文件写入失败")
+		return
+	}
+	r.OkMesage(c, "两步验证已关闭")
+}
+
+// twoFactorDisableFailed 记录关闭两步验证时密码或验证码错误
+func twoFactorDisableFailed(limiter *lib.LoginLimiter, keys []string, ip, username string) {
+	locked := limiter.Fail(keys...)
+	log.Printf("关闭两步验证失败[ip=%s user=%s]", ip, username)
+	if locked {
+		log.Printf("失败次数过多,已锁定%v[ip=%s user=%s]", lib.LoginLockDuration, ip, username)
+	}
+}
+
+// DisableTwoFactor 关闭两步验证并清除密钥和恢复码,也用于命令行在设备丢失时关闭
+func DisableTwoFactor(change config.Change) error {
+	err := config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		return sjson.Delete(cfg.Raw, "totp")
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("两步验证已关闭")
+	return nil
+}
@@ -2,24 +2,27 @@ package serve
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"xuanwu/audit"
 	"xuanwu/config"
 	r "xuanwu/gin/response"
 	"xuanwu/lib"
-	"xuanwu/lib/pathutil"
 	"xuanwu/xuanwu"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
 // UserProfile 用户配置结构体
 type UserProfile struct {
 	Username         string `json:"username,omitempty"`          // 用户名
-	Password         string `json:"password,omitempty"`          // 密码(SHA256)
+	Password         string `json:"password,omitempty"`          // 密码(前端SHA256,服务端bcrypt存储)
 	OldPassword      string `json:"old_password,omitempty"`      // 旧密码(SHA256)
 	CookieExpireDays int    `json:"cookie_expire_days,omitempty"` // Cookie过期天数
 	LogCleanDays     int    `json:"log_clean_days,omitempty"`     // 日志清理天数
+	AuditRetainDays  int    `json:"audit_retain_days,omitempty"`  // 审计日志保留天数
 }
 
 // UserInfo 用户基本信息
@@ -28,6 +31,9 @@ type UserInfo struct {
 	Password string `json:"password"`
 }
 
+// 旧密码错误,用于在修改配置时中止写入
+var errOldPassword = errors.New("旧密码错误")
+
 // 全局配置缓存
 var (
 	globalCookieExpireDays = 30 // 默认30天
@@ -48,6 +54,10 @@ func InitGlobalConfig() {
 	if days := cfg.Get("log_clean_days").Int(); days > 0 {
 		globalLogCleanDays = int(days)
 	}
+
+	if days := cfg.Get("audit_retain_days").Int(); days > 0 {
+		audit.SetRetainDays(int(days))
+	}
 }
 
 // GetCookieExpireDays 获取当前Cookie过期天数
@@ -77,6 +87,33 @@ func GetUserInfo() UserInfo {
 	return userInfo
 }
 
+// ResetPassword 重置管理员密码,用于命令行忘记密码时恢复,password为明文,username为空时不修改用户名
+func ResetPassword(username, password string, change config.Change) error {
+	// 前端登录时提交的是密码的SHA256
+	hash, err := lib.HashPassword(lib.SHA256(password))
+	if err != nil {
+		return err
+	}
+	return config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		jsonStr := cfg.Raw
+		if username != "" {
+			jsonStr, _ = sjson.Set(jsonStr, "username", username)
+		}
+		return sjson.Set(jsonStr, "password", hash)
+	})
+}
+
+// UpgradePasswordHash 将配置文件中的旧版SHA256密码升级为bcrypt
+func UpgradePasswordHash(password string) error {
+	hash, err := lib.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return config.UpdateConfig(config.Change{User: "system", Reason: "升级密码哈希"}, func(cfg gjson.Result) (string, error) {
+		return sjson.Set(cfg.Raw, "password", hash)
+	})
+}
+
 // HandlerGetUserProfile 获取用户配置
 func (p *ApiData) HandlerGetUserProfile(c *gin.Context) {
 	cfg, err := config.ReadConfigFileToJson()
@@ -89,6 +126,7 @@ func (p *ApiData) HandlerGetUserProfile(c *gin.Context) {
 		Username:         cfg.Get("username").String(),
 		CookieExpireDays: int(cfg.Get("cookie_expire_days").Int()),
 		LogCleanDays:     int(cfg.Get("log_clean_days").Int()),
+		AuditRetainDays:  audit.GetRetainDays(),
 	}
 
 	if profile.Username == "" {
@@ -119,6 +157,7 @@ func (p *ApiData) HandlerUpdateUserProfile(c *gin.Context) {
 		"old_password":      true,
 		"cookie_expire_days": true,
 		"log_clean_days":    true,
+		"audit_retain_days": true,
 	}
 
 	// 检查参数名
@@ -138,13 +177,6 @@ func (p *ApiData) HandlerUpdateUserProfile(c *gin.Context) {
 		return
 	}
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		r.ErrMesage(c, "读取配置文件失败")
-		return
-	}
-
-	jsonStr := cfg.Raw
 	needResetToken := false
 
 	// 更新用户名
@@ -156,49 +188,78 @@ func (p *ApiData) HandlerUpdateUserProfile(c *gin.Context) {
 			r.ErrMesage(c, "用户名格式错误")
 			return
 		}
-		jsonStr, _ = sjson.Set(jsonStr, "username", req.Username)
 		needResetToken = true
 	}
 
 	// 更新密码
+	var passwordHash string
 	if req.Password != "" && req.OldPassword != "" {
-		currentPass := cfg.Get("password").String()
-		if currentPass != req.OldPassword {
-			r.ErrMesage(c, "旧密码错误")
-			return
-		}
-
 		if req.Password == req.OldPassword {
 			r.ErrMesage(c, "新密码不能与旧密码相同")
 			return
 		}
 
-		jsonStr, _ = sjson.Set(jsonStr, "password", req.Password)
+		hash, err := lib.HashPassword(req.Password)
+		if err != nil {
+			r.ErrMesage(c, "密码加密失败")
+			return
+		}
+		passwordHash = hash
 		needResetToken = true
 	} else if req.Password != "" {
 		r.ErrMesage(c, "请提供旧密码")
 		return
 	}
 
-	// 更新Cookie过期天数
+	// 在配置锁内校验旧密码并写入
+	change := config.Change{User: c.GetString("username"), Reason: "修改用户设置"}
+	err := config.UpdateConfig(change, func(cfg gjson.Result) (string, error) {
+		jsonStr := cfg.Raw
+		if req.Username != "" {
+			jsonStr, _ = sjson.Set(jsonStr, "username", req.Username)
+		}
+		if passwordHash != "" {
+			if ok, _ := lib.CheckPassword(cfg.Get("password").String(), req.OldPassword); !ok {
+				return "", errOldPassword
+			}
+			jsonStr, _ = sjson.Set(jsonStr, "password", passwordHash)
+		}
+		if req.CookieExpireDays > 0 {
+			jsonStr, _ = sjson.Set(jsonStr, "cookie_expire_days", req.CookieExpireDays)
+		}
+		if req.LogCleanDays > 0 {
+			jsonStr, _ = sjson.Set(jsonStr, "log_clean_days", req.LogCleanDays)
+		}
+		if req.AuditRetainDays > 0 {
+			jsonStr, _ = sjson.Set(jsonStr, "audit_retain_days", req.AuditRetainDays)
+		}
+		return jsonStr, nil
+	})
+	if err == errOldPassword {
+		r.ErrMesage(c, "旧密码错误")
+		return
+	}
+	var validErr *config.ValidationError
+	if errors.As(err, &validErr) {
+		r.ErrMesage(c, validErr.Error())
+		return
+	}
+	if err != nil {
+		r.ErrMesage(c, "配置文件写入失败")
+		return
+	}
+
+	// 写入成功后更新缓存的配置
 	if req.CookieExpireDays > 0 {
-		jsonStr, _ = sjson.Set(jsonStr, "cookie_expire_days", req.CookieExpireDays)
 		globalCookieExpireDays = req.CookieExpireDays
 	}
-
-	// 更新日志清理天数
 	if req.LogCleanDays > 0 {
-		jsonStr, _ = sjson.Set(jsonStr, "log_clean_days", req.LogCleanDays)
 		globalLogCleanDays = req.LogCleanDays
 		// 更新系统任务中的清理天数
 		xuanwu.UpdateLogCleanDays(req.LogCleanDays)
 	}
-
-	// 写入配置文件
-	configPath := pathutil.GetDataPath("config.json")
-	if err := config.WriteConfigFile(configPath, []byte(jsonStr)); err != nil {
-		r.ErrMesage(c, "配置文件写入失败")
-		return
+	if req.AuditRetainDays > 0 {
+		audit.SetRetainDays(req.AuditRetainDays)
 	}
 
 	// 如果修改了用户名或密码，强制用户重新登录
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MaxLoginFailures  = 5                // 连续失败多少次后锁定
+	LoginLockDuration = 15 * time.Minute // 锁定时长
+	loginBaseDelay    = time.Second      // 失败后的基础等待时间,每次失败翻倍
+	loginMaxDelay     = 30 * time.Second // 单次等待时间上限
+	loginRecordTTL    = time.Hour        // 无新失败记录时多久后清除
+)
+
+// loginRecord 某个IP或账号的失败记录
+type loginRecord struct {
+	failures    int
+	lastFail    time.Time
+	nextAllowed time.Time
+	lockedUntil time.Time
+}
+
+// LockoutInfo 对外展示的锁定信息
+type LockoutInfo struct {
+	Key         string    `json:"key"`          // ip:xxx 或 user:xxx
+	Failures    int       `json:"failures"`     // 连续失败次数
+	LastFail    time.Time `json:"last_fail"`    // 最后一次失败时间
+	LockedUntil time.Time `json:"locked_until"` // 锁定截止时间,零值表示未锁定
+	Locked      bool      `json:"locked"`       // 当前是否处于锁定状态
+}
+
+// LoginLimiter 登录失败限流
+type LoginLimiter struct {
+	records map[string]*loginRecord
+	mutex   sync.Mutex
+}
+
+var (
+	loginLimiter     *LoginLimiter
+	loginLimiterOnce sync.Once
+)
+
+// GetLoginLimiter 获取登录限流单例
+func GetLoginLimiter() *LoginLimiter {
+	loginLimiterOnce.Do(func() {
+		loginLimiter = &LoginLimiter{
+			records: make(map[string]*loginRecord),
+		}
+	})
+	return loginLimiter
+}
+
+// cleanup 清除过期记录,调用方需持有锁
+func (l *LoginLimiter) cleanup(now time.Time) {
+	for key, rec := range l.records {
+		if now.After(rec.lockedUntil) && now.Sub(rec.lastFail) > loginRecordTTL {
+			delete(l.records, key)
+		}
+	}
+}
+
+// Check 检查是否允许尝试登录,返回需要等待的时间,0表示允许。
+// 允许时在同一次加锁中先按一次失败计数,验证成功后由 Success 清除,
+// 并发的多个请求因此不能在计数前同时通过检查。
+// 任一key需要等待时所有key都不计数,调用方先传IP再传账号,IP被限流的请求不会累加账号的失败次数。
+// 账号锁定仍可能被多个IP合力触发,这是为防止分布式猜测密码而接受的代价,锁定可通过 /lockouts/clear 接口清除
+func (l *LoginLimiter) Check(keys ...string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	var wait time.Duration
+	for _, key := range keys {
+		rec, ok := l.records[key]
+		if !ok {
+			continue
+		}
+		if d := rec.lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+		if d := rec.nextAllowed.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, key := range keys {
+		l.reserve(key, now)
+	}
+	return 0
+}
+
+// reserve 记录一次尝试,调用方需持有锁
+func (l *LoginLimiter) reserve(key string, now time.Time) {
+	rec, ok := l.records[key]
+	if !ok {
+		rec = &loginRecord{}
+		l.records[key] = rec
+	}
+	// 锁定已过期的记录重新计数
+	if !rec.lockedUntil.IsZero() && now.After(rec.lockedUntil) {
+		rec.failures = 0
+		rec.lockedUntil = time.Time{}
+	}
+	rec.failures++
+	rec.lastFail = now
+
+	// 指数递增的等待时间
+	delay := loginBaseDelay << (rec.failures - 1)
+	if delay > loginMaxDelay || delay <= 0 {
+		delay = loginMaxDelay
+	}
+	rec.nextAllowed = now.Add(delay)
+
+	if rec.failures >= MaxLoginFailures {
+		rec.lockedUntil = now.Add(LoginLockDuration)
+	}
+}
+
+// Fail 验证失败,失败次数已在 Check 中计入,返回是否因此处于锁定
+func (l *LoginLimiter) Fail(keys ...string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if rec, ok := l.records[key]; ok && now.Before(rec.lockedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Success 登录成功,清除失败记录和 Check 中计入的本次尝试
+func (l *LoginLimiter) Success(keys ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, key := range keys {
+		delete(l.records, key)
+	}
+}
+
+// List 获取所有失败记录
+func (l *LoginLimiter) List() []LockoutInfo {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	list := make([]LockoutInfo, 0, len(l.records))
+	for key, rec := range l.records {
+		list = append(list, LockoutInfo{
+			Key:         key,
+			Failures:    rec.failures,
+			LastFail:    rec.lastFail,
+			LockedUntil: rec.lockedUntil,
+			Locked:      now.Before(rec.lockedUntil),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastFail.After(list[j].LastFail)
+	})
+	return list
+}
+
+// Clear 清除指定记录,key为空时清除全部,返回清除的条数
+func (l *LoginLimiter) Clear(key string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if key == "" {
+		n := len(l.records)
+		l.records = make(map[string]*loginRecord)
+		return n
+	}
+	if _, ok := l.records[key]; ok {
+		delete(l.records, key)
+		return 1
+	}
+	return 0
+}
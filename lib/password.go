@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"crypto/subtle"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 旧版本直接存储的SHA256十六进制串
+var legacyHashRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// HashPassword 使用bcrypt对前端传来的密码(SHA256)再做一次慢哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// IsLegacyHash 判断存储的密码是否为旧版本的SHA256格式
+func IsLegacyHash(stored string) bool {
+	return legacyHashRegex.MatchString(strings.ToLower(stored))
+}
+
+// CheckPassword 校验密码
+// stored 为配置文件中存储的值, password 为前端传来的SHA256
+// 返回值 needRehash 表示校验通过但存储格式为旧版本,需要升级
+func CheckPassword(stored, password string) (ok bool, needRehash bool) {
+	if stored == "" || password == "" {
+		return false, false
+	}
+	if IsLegacyHash(stored) {
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(stored)), []byte(strings.ToLower(password))) == 1 {
+			return true, true
+		}
+		return false, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	return true, false
+}
@@ -14,6 +14,7 @@ const (
 var (
 	executablePath string
 	rootDir        string
+	dataDir        string // 数据目录,默认为程序目录下的data
 )
 
 func init() {
@@ -23,6 +24,7 @@ func init() {
 		panic("无法获取可执行文件路径: " + err.Error())
 	}
 	rootDir = filepath.Dir(executablePath)
+	dataDir = filepath.Join(rootDir, DATA_DIR)
 }
 
 // GetExecutablePath 获取可执行文件路径
@@ -35,19 +37,34 @@ func GetRootDir() string {
 	return rootDir
 }
 
+// SetDataDir 设置数据目录,相对路径基于当前工作目录
+func SetDataDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	dataDir = abs
+	return nil
+}
+
+// GetDataDir 获取数据目录
+func GetDataDir() string {
+	return dataDir
+}
+
 // GetDataPath 获取数据目录下的路径
 func GetDataPath(subPath string) string {
-	return filepath.Join(rootDir, DATA_DIR, subPath)
+	return filepath.Join(dataDir, subPath)
 }
 
 // GetLogPath 获取日志文件路径
 func GetLogPath(filename string) string {
-	return filepath.Join(rootDir, DATA_DIR, LOG_DIR, filename)
+	return filepath.Join(dataDir, LOG_DIR, filename)
 }
 
 // GetConfigPath 获取配置文件路径
 func GetConfigPath(filename string) string {
-	return filepath.Join(rootDir, DATA_DIR, CONFIG_DIR, filename)
+	return filepath.Join(dataDir, CONFIG_DIR, filename)
 }
 
 // EnsureDir 确保目录存在
@@ -64,7 +81,7 @@ func EnsureFile(path string) error {
 	if err := EnsureDir(dir); err != nil {
 		return err
 	}
-	
+
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		file, err := os.Create(path)
 		if err != nil {
@@ -79,4 +96,45 @@ func EnsureFile(path string) error {
 func IsFileExist(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil || !os.IsNotExist(err)
-} 
\ No newline at end of file
+}
+
+// WriteFileAtomic 先写入同目录下的临时文件并同步到磁盘,再重命名替换目标文件,
+// 避免写入中途崩溃导致文件内容为空或不完整
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := EnsureDir(dir); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // 重命名成功后删除不会生效
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// 同步目录,确保重命名落盘(Windows不支持,忽略错误)
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// fileID 文件的 设备号:inode号
+func fileID(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(st.Dev), 10) + ":" + strconv.FormatUint(uint64(st.Ino), 10)
+}
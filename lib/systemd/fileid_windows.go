@@ -0,0 +1,10 @@
+//go:build windows
+
+package systemd
+
+import "os"
+
+// fileID windows上没有systemd
+func fileID(info os.FileInfo) string {
+	return ""
+}
@@ -0,0 +1,103 @@
+package systemd
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnderSystemd 是否由systemd启动
+func UnderSystemd() bool {
+	return os.Getenv("INVOCATION_ID") != "" || os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// JournalStream 标准错误是否直接连接到journald,journald会自己记录时间
+func JournalStream() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	// JOURNAL_STREAM 格式为 设备号:inode号
+	dev, ino, ok := strings.Cut(stream, ":")
+	if !ok {
+		return false
+	}
+	return fileID(info) == dev+":"+ino
+}
+
+// Notify 向systemd发送状态通知,不是由systemd启动时返回false
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// @开头的是抽象socket
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ready 通知systemd启动完成
+func Ready(status string) (bool, error) {
+	return Notify("READY=1\nSTATUS=" + status)
+}
+
+// Stopping 通知systemd开始关闭
+func Stopping() (bool, error) {
+	return Notify("STOPPING=1")
+}
+
+// Reloading 通知systemd开始重新加载配置,完成后需要调用 Ready
+func Reloading() (bool, error) {
+	return Notify("RELOADING=1")
+}
+
+// WatchdogInterval 获取看门狗超时时间,未开启时返回0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// 指定了PID时只对该进程生效
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog 开启看门狗时按超时时间的一半定期调用check,检查通过后才通知,返回是否开启。
+// 检查失败时不通知,超时后由systemd按服务配置处理
+func StartWatchdog(check func(timeout time.Duration) error) bool {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return false
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := check(interval / 4); err != nil {
+				log.Printf("存活检查失败,不通知systemd看门狗: %v", err)
+				continue
+			}
+			Notify("WATCHDOG=1")
+		}
+	}()
+	return true
+}
@@ -0,0 +1,292 @@
+package xwlog
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// 终端输出中的转义序列,只有SGR(ESC [ ... m)表示颜色和字体样式
+const escape = '\x1b'
+
+// Span 一段相同样式的文本,颜色为 #rrggbb,为空时使用默认颜色
+type Span struct {
+	Text      string `json:"text"`
+	FG        string `json:"fg,omitempty"`
+	BG        string `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Dim       bool   `json:"dim,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+}
+
+// 16色的默认配色
+var ansiColors = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+// HasANSI 是否包含转义序列
+func HasANSI(text string) bool {
+	return strings.IndexByte(text, escape) >= 0
+}
+
+// scanEscape 返回从i开始的转义序列的长度和是否为SGR,text[i]为ESC
+func scanEscape(text string, i int) (n int, sgr bool) {
+	if i+1 >= len(text) {
+		return 1, false
+	}
+	switch text[i+1] {
+	case '[':
+		// CSI: 参数和中间字节,以0x40-0x7E结束
+		for j := i + 2; j < len(text); j++ {
+			if c := text[j]; c >= 0x40 && c <= 0x7e {
+				return j + 1 - i, c == 'm'
+			}
+		}
+		return len(text) - i, false
+	case ']', 'P', '_', '^':
+		// OSC等字符串序列,以BEL或ESC \结束
+		for j := i + 2; j < len(text); j++ {
+			if text[j] == '\a' {
+				return j + 1 - i, false
+			}
+			if text[j] == escape && j+1 < len(text) && text[j+1] == '\\' {
+				return j + 2 - i, false
+			}
+		}
+		return len(text) - i, false
+	}
+	return 2, false
+}
+
+// StripANSI 去掉所有转义序列
+func StripANSI(text string) string {
+	return filterANSI(text, false)
+}
+
+// StripANSIIndex 去掉所有转义序列,同时返回结果中每个字节在原文本中的位置
+func StripANSIIndex(text string) (string, []int) {
+	var b strings.Builder
+	b.Grow(len(text))
+	index := make([]int, 0, len(text))
+	for i := 0; i < len(text); {
+		if text[i] == escape {
+			n, _ := scanEscape(text, i)
+			i += n
+			continue
+		}
+		b.WriteByte(text[i])
+		index = append(index, i)
+		i++
+	}
+	return b.String(), index
+}
+
+// KeepSGR 只保留表示颜色和样式的转义序列,去掉光标移动、清屏、标题等
+func KeepSGR(text string) string {
+	return filterANSI(text, true)
+}
+
+func filterANSI(text string, keepSGR bool) string {
+	if !HasANSI(text) {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); {
+		if text[i] != escape {
+			j := strings.IndexByte(text[i:], escape)
+			if j < 0 {
+				j = len(text) - i
+			}
+			b.WriteString(text[i : i+j])
+			i += j
+			continue
+		}
+		n, sgr := scanEscape(text, i)
+		if sgr && keepSGR {
+			b.WriteString(text[i : i+n])
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// ParseANSI 将带颜色的文本按样式分段,不支持的转义序列忽略
+func ParseANSI(text string) []Span {
+	var spans []Span
+	var style Span
+	start := 0
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			span := style
+			span.Text = b.String()
+			spans = append(spans, span)
+			b.Reset()
+		}
+	}
+	for i := 0; i < len(text); {
+		if text[i] != escape {
+			i++
+			continue
+		}
+		b.WriteString(text[start:i])
+		n, sgr := scanEscape(text, i)
+		if sgr {
+			flush()
+			style.applySGR(text[i+2 : i+n-1])
+		}
+		i += n
+		start = i
+	}
+	b.WriteString(text[start:])
+	flush()
+	return spans
+}
+
+// applySGR 按SGR参数修改样式
+func (s *Span) applySGR(params string) {
+	if params == "" {
+		params = "0"
+	}
+	codes := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case code == 0:
+			*s = Span{}
+		case code == 1:
+			s.Bold = true
+		case code == 2:
+			s.Dim = true
+		case code == 3:
+			s.Italic = true
+		case code == 4:
+			s.Underline = true
+		case code == 22:
+			s.Bold, s.Dim = false, false
+		case code == 23:
+			s.Italic = false
+		case code == 24:
+			s.Underline = false
+		case code >= 30 && code <= 37:
+			s.FG = ansiColors[code-30]
+		case code >= 90 && code <= 97:
+			s.FG = ansiColors[code-90+8]
+		case code == 39:
+			s.FG = ""
+		case code >= 40 && code <= 47:
+			s.BG = ansiColors[code-40]
+		case code >= 100 && code <= 107:
+			s.BG = ansiColors[code-100+8]
+		case code == 49:
+			s.BG = ""
+		case code == 38 || code == 48:
+			// 38;5;n 256色, 38;2;r;g;b 真彩色
+			color, used := extendedColor(codes[i+1:])
+			i += used
+			if code == 38 {
+				s.FG = color
+			} else {
+				s.BG = color
+			}
+		}
+	}
+}
+
+// extendedColor 解析256色和真彩色参数,返回颜色和使用的参数个数
+func extendedColor(args []string) (string, int) {
+	num := func(i int) int {
+		if i >= len(args) {
+			return -1
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 || n > 255 {
+			return -1
+		}
+		return n
+	}
+	switch num(0) {
+	case 5:
+		n := num(1)
+		if n < 0 {
+			return "", len(args)
+		}
+		return color256(n), 2
+	case 2:
+		r, g, b := num(1), num(2), num(3)
+		if r < 0 || g < 0 || b < 0 {
+			return "", len(args)
+		}
+		return fmt.Sprintf("#%02x%02x%02x", r, g, b), 4
+	}
+	return "", 0
+}
+
+// color256 256色调色板中的颜色
+func color256(n int) string {
+	switch {
+	case n < 16:
+		return ansiColors[n]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	}
+	gray := 8 + (n-232)*10
+	return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+}
+
+// SpansHTML 将分段的文本转换为HTML,文本已转义
+func SpansHTML(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		var style []string
+		if span.FG != "" {
+			style = append(style, "color:"+span.FG)
+		}
+		if span.BG != "" {
+			style = append(style, "background-color:"+span.BG)
+		}
+		if span.Bold {
+			style = append(style, "font-weight:bold")
+		}
+		if span.Dim {
+			style = append(style, "opacity:0.7")
+		}
+		if span.Italic {
+			style = append(style, "font-style:italic")
+		}
+		if span.Underline {
+			style = append(style, "text-decoration:underline")
+		}
+		text := html.EscapeString(span.Text)
+		if len(style) == 0 {
+			b.WriteString(text)
+			continue
+		}
+		fmt.Fprintf(&b, `<span style="%s">%s</span>`, strings.Join(style, ";"), text)
+	}
+	return b.String()
+}
+
+// LinesHTML 将多行输出转换为HTML,每行以换行结束,颜色转换为带样式的span
+func LinesHTML(lines []Line) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(SpansHTML(ParseANSI(line.Text)))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,280 @@
+package xwlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+)
+
+// 任务日志中每次执行前的时间头,独占一行
+var blockHeaderRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\r?$`)
+
+// 时间头行的最大长度,更长的行不可能是时间头
+const blockHeaderMaxLen = 21
+
+// CleanStat 单个文件或任务执行日志的清理结果
+type CleanStat struct {
+	File          string `json:"file"`           // 日志目录下的相对路径
+	Before        int64  `json:"before"`         // 清理前大小
+	After         int64  `json:"after"`          // 清理后大小
+	Freed         int64  `json:"freed"`          // 释放的字节数
+	BlocksRemoved int    `json:"blocks_removed"` // 删除的日志块,执行日志为删除的执行次数
+	BlocksKept    int    `json:"blocks_kept"`    // 保留的日志块
+	Error         string `json:"error,omitempty"`
+}
+
+// CleanReport 一次日志清理的结果
+type CleanReport struct {
+	Time          time.Time   `json:"time"`
+	Days          int         `json:"days"`
+	Duration      int64       `json:"duration_ms"`
+	Freed         int64       `json:"freed"`
+	BlocksRemoved int         `json:"blocks_removed"`
+	Files         []CleanStat `json:"files"` // 只包含有变化或出错的文件
+}
+
+var (
+	lastCleanup *CleanReport
+	cleanLock   sync.Mutex // 同一时间只执行一次清理
+)
+
+// LastCleanup 最近一次日志清理的结果,程序启动后未清理过时返回nil
+func LastCleanup() *CleanReport {
+	cleanLock.Lock()
+	defer cleanLock.Unlock()
+	return lastCleanup
+}
+
+// Cleanup 清理任务日志中超过天数的内容和超出保留策略的执行日志,结果写入系统日志
+func Cleanup(cleanDays int) (*CleanReport, error) {
+	cleanLock.Lock()
+	defer cleanLock.Unlock()
+
+	start := time.Now()
+	report := &CleanReport{Time: start, Days: cleanDays, Files: []CleanStat{}}
+	stats, err := CleanLogs(cleanDays)
+	if err != nil {
+		return nil, err
+	}
+	runStats, err := CleanRuns()
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range append(stats, runStats...) {
+		if stat.Error != "" {
+			Warnf("清理日志失败[%s]: %s", stat.File, stat.Error)
+		} else if stat.BlocksRemoved > 0 {
+			Component(defaultComponent).Log(LevelInfo, "已清理过期日志", map[string]interface{}{
+				"file":           stat.File,
+				"freed":          stat.Freed,
+				"blocks_removed": stat.BlocksRemoved,
+				"blocks_kept":    stat.BlocksKept,
+			})
+		} else {
+			continue
+		}
+		report.Freed += stat.Freed
+		report.BlocksRemoved += stat.BlocksRemoved
+		report.Files = append(report.Files, stat)
+	}
+	report.Duration = time.Since(start).Milliseconds()
+	Infof("日志清理完成: 清理%d个文件,删除%d个日志块,释放%d字节", len(report.Files), report.BlocksRemoved, report.Freed)
+	lastCleanup = report
+	return report, nil
+}
+
+// CleanLogs 清理日志目录下任务日志中超过天数的执行记录,逐行读取写入临时文件后替换原文件。
+// main.log及其轮转文件由轮转配置清理,执行日志的兼容视图由执行日志的保留策略清理
+func CleanLogs(cleanDays int) ([]CleanStat, error) {
+	if cleanDays <= 0 {
+		return nil, fmt.Errorf("清理天数必须大于0")
+	}
+
+	logDir := pathutil.GetDataPath(pathutil.LOG_DIR)
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		return nil, fmt.Errorf("读取日志目录失败: %v", err)
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -cleanDays)
+	var stats []CleanStat
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".log" || name == MAIN_LOG || isMainBackup(name) || isCompatView(name) {
+			continue
+		}
+		stat := cleanFile(filepath.Join(logDir, name), cutoff)
+		stat.File = name
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// blockFilter 按时间头将日志分块,过期的块不写入。出现第一个过期的块之前不写入,
+// 由 begin 创建输出并复制之前保留的内容
+type blockFilter struct {
+	out     *bufio.Writer
+	begin   func(kept int64) (*bufio.Writer, error)
+	cutoff  time.Time
+	drop    bool   // 当前块是否过期
+	pending []byte // 暂存的空行,属于下一个块还是当前块取决于下一行
+	stat    *CleanStat
+}
+
+// line 处理一整行(或超长行的一部分),first表示是否为一行的开头
+func (f *blockFilter) line(data []byte, first bool) error {
+	if first && len(data) <= blockHeaderMaxLen+1 && blockHeaderRegex.Match(bytes.TrimRight(data, "\n")) {
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", string(data[:19]), time.Local); err == nil {
+			f.drop = t.Before(f.cutoff)
+			if f.drop {
+				f.stat.BlocksRemoved++
+				if f.out == nil {
+					// 之前的内容都保留,暂存的空行属于这个过期的块
+					out, err := f.begin(f.stat.After)
+					if err != nil {
+						return err
+					}
+					f.out = out
+				}
+			} else {
+				f.stat.BlocksKept++
+			}
+			// 时间头前的空行属于新的块
+			if err := f.flushPending(); err != nil {
+				return err
+			}
+			return f.write(data)
+		}
+	}
+	// 空行暂存,由下一行决定归属,连续的空行中前面的属于当前块
+	if first && (bytes.Equal(data, []byte("\n")) || bytes.Equal(data, []byte("\r\n"))) {
+		if err := f.flushPending(); err != nil {
+			return err
+		}
+		f.pending = append([]byte(nil), data...)
+		return nil
+	}
+	if err := f.flushPending(); err != nil {
+		return err
+	}
+	return f.write(data)
+}
+
+// flushPending 将暂存的空行按当前块写入
+func (f *blockFilter) flushPending() error {
+	if f.pending == nil {
+		return nil
+	}
+	err := f.write(f.pending)
+	f.pending = nil
+	return err
+}
+
+func (f *blockFilter) write(data []byte) error {
+	if f.drop {
+		return nil
+	}
+	f.stat.After += int64(len(data))
+	if f.out == nil {
+		return nil
+	}
+	_, err := f.out.Write(data)
+	return err
+}
+
+// cleanFile 清理单个文件,没有过期内容时不创建临时文件,也不修改原文件
+func cleanFile(path string, cutoff time.Time) (stat CleanStat) {
+	fail := func(err error) CleanStat {
+		stat.After = stat.Before
+		stat.BlocksRemoved, stat.BlocksKept = 0, 0
+		stat.Error = err.Error()
+		return stat
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fail(err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	stat.Before = info.Size()
+
+	var tmp *os.File
+	var tmpPath string
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	// 出现第一个过期的块时才创建临时文件,复制之前保留的内容
+	begin := func(kept int64) (*bufio.Writer, error) {
+		f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		tmp, tmpPath = f, f.Name()
+		out := bufio.NewWriterSize(tmp, 64*1024)
+		if _, err := io.Copy(out, io.NewSectionReader(src, 0, kept)); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	filter := &blockFilter{begin: begin, cutoff: cutoff, stat: &stat}
+	reader := bufio.NewReaderSize(src, 64*1024)
+	first := true
+	for {
+		// ReadSlice 在超长行时返回缓冲区大小的片段,不会把整行读入内存
+		data, err := reader.ReadSlice('\n')
+		if len(data) > 0 {
+			if werr := filter.line(data, first); werr != nil {
+				return fail(werr)
+			}
+			first = data[len(data)-1] == '\n'
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err := filter.flushPending(); err != nil {
+		return fail(err)
+	}
+
+	if stat.BlocksRemoved == 0 {
+		stat.After = stat.Before
+		return stat
+	}
+	if err := filter.out.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(err)
+	}
+	tmp = nil
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fail(err)
+	}
+	stat.Freed = stat.Before - stat.After
+	return stat
+}
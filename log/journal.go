@@ -0,0 +1,9 @@
+package xwlog
+
+// 日志级别对应的syslog优先级前缀,journald据此设置日志优先级
+var journalPriority = map[Level]string{
+	LevelDebug: "<7>",
+	LevelInfo:  "<6>",
+	LevelWarn:  "<4>",
+	LevelError: "<3>",
+}
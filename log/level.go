@@ -0,0 +1,72 @@
+package xwlog
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Level 日志级别
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// 默认日志级别,未单独配置级别的组件使用,默认info
+var currentLevel = int32(LevelInfo)
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel 解析日志级别名称,不区分大小写
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		name = "warn"
+	}
+	for l, n := range levelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("未知的日志级别: %s", name)
+}
+
+// SetLevel 设置日志级别
+func SetLevel(l Level) {
+	atomic.StoreInt32(&currentLevel, int32(l))
+}
+
+// GetLevel 获取当前日志级别
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&currentLevel))
+}
+
+// logf 按级别写入main组件的日志
+func logf(l Level, format string, args ...interface{}) {
+	Component(defaultComponent).Log(l, fmt.Sprintf(format, args...), nil)
+}
+
+// Debugf 调试日志
+func Debugf(format string, args ...interface{}) { logf(LevelDebug, format, args...) }
+
+// Infof 普通日志
+func Infof(format string, args ...interface{}) { logf(LevelInfo, format, args...) }
+
+// Warnf 警告日志
+func Warnf(format string, args ...interface{}) { logf(LevelWarn, format, args...) }
+
+// Errorf 错误日志
+func Errorf(format string, args ...interface{}) { logf(LevelError, format, args...) }
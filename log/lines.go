@@ -0,0 +1,243 @@
+package xwlog
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// 执行日志每行的格式为 "时间 输出流 内容",如
+// 2006-01-02 15:04:05.000000 O hello
+const (
+	lineTimeFormat = "2006-01-02 15:04:05.000000"
+	linePrefixLen  = len(lineTimeFormat) + 2 // 时间、空格和输出流标记
+)
+
+// Stream 输出流
+type Stream byte
+
+const (
+	StreamStdout Stream = 'O' // 标准输出
+	StreamStderr Stream = 'E' // 标准错误
+	StreamSystem Stream = 'S' // 系统记录的信息,如执行用时和失败原因
+)
+
+// String 输出流名称,用于接口参数和返回值
+func (s Stream) String() string {
+	switch s {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	case StreamSystem:
+		return "system"
+	}
+	return ""
+}
+
+// MarshalText 序列化为输出流名称
+func (s Stream) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseStream 解析输出流名称,为空时返回0表示不限制
+func ParseStream(name string) (Stream, bool) {
+	switch strings.ToLower(name) {
+	case "":
+		return 0, true
+	case "stdout":
+		return StreamStdout, true
+	case "stderr":
+		return StreamStderr, true
+	case "system":
+		return StreamSystem, true
+	}
+	return 0, false
+}
+
+// Line 执行日志中的一行
+type Line struct {
+	Time   time.Time `json:"time"`
+	Offset int64     `json:"offset_ms"` // 距执行开始的毫秒数
+	Stream Stream    `json:"stream"`
+	Text   string    `json:"text"`
+	Spans  []Span    `json:"spans,omitempty"` // 带颜色时按样式分段,此时Text为去掉转义序列的文本
+}
+
+// ParseColors 将带颜色的行转换为分段的样式,用于接口返回
+func ParseColors(lines []Line) {
+	for i := range lines {
+		if HasANSI(lines[i].Text) {
+			lines[i].Spans = ParseANSI(lines[i].Text)
+			lines[i].Text = StripANSI(lines[i].Text)
+		}
+	}
+}
+
+// FormatLine 将一行输出转换为存储格式
+func FormatLine(t time.Time, stream Stream, text string) []byte {
+	b := make([]byte, 0, linePrefixLen+len(text)+2)
+	b = t.AppendFormat(b, lineTimeFormat)
+	b = append(b, ' ', byte(stream), ' ')
+	b = append(b, text...)
+	return append(b, '\n')
+}
+
+// ParseLine 解析存储格式的一行,不是该格式时(如旧版本的执行日志)ok为false
+func ParseLine(b []byte) (line Line, ok bool) {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	if len(b) < linePrefixLen || b[linePrefixLen-2] != ' ' || (len(b) > linePrefixLen && b[linePrefixLen] != ' ') {
+		return line, false
+	}
+	stream := Stream(b[linePrefixLen-1])
+	if stream.String() == "" {
+		return line, false
+	}
+	t, err := time.ParseInLocation(lineTimeFormat, string(b[:len(lineTimeFormat)]), time.Local)
+	if err != nil {
+		return line, false
+	}
+	line.Time, line.Stream = t, stream
+	if len(b) > linePrefixLen {
+		line.Text = string(b[linePrefixLen+1:])
+	}
+	return line, true
+}
+
+// decodeLine 解析一行,不是存储格式时作为标准输出的内容
+func decodeLine(b []byte) Line {
+	if line, ok := ParseLine(b); ok {
+		return line
+	}
+	return Line{Stream: StreamStdout, Text: string(bytes.TrimSuffix(b, []byte("\n")))}
+}
+
+// DecodeText 将执行日志转换为纯文本,只处理完整的行,返回读取的字节数
+func DecodeText(r io.Reader, w io.Writer) (int64, error) {
+	reader := bufio.NewReader(r)
+	var read int64
+	for {
+		b, err := reader.ReadBytes('\n')
+		if len(b) > 0 && b[len(b)-1] == '\n' {
+			read += int64(len(b))
+			if _, werr := io.WriteString(w, decodeLine(b).Text+"\n"); werr != nil {
+				return read, werr
+			}
+		}
+		if err == io.EOF {
+			return read, nil
+		}
+		if err != nil {
+			return read, err
+		}
+	}
+}
+
+// LineChunk 按字节范围读取并解析的执行日志
+type LineChunk struct {
+	Lines  []Line `json:"lines"`
+	Offset int64  `json:"offset"`
+	Next   int64  `json:"next"`
+	Size   int64  `json:"size"`
+	EOF    bool   `json:"eof"`
+}
+
+// ReadLines 从offset开始读取最多limit字节的完整行,stream不为0时只返回该输出流。
+// start为执行开始时间,用于计算每行的相对时间
+func ReadLines(path string, offset, limit int64, stream Stream, start time.Time) (*LineChunk, error) {
+	chunk, err := ReadRange(path, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(chunk.Content)
+	// 只返回完整的行,不完整的部分下次读取,超过limit的长行分段返回
+	if !chunk.EOF {
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i+1]
+		}
+	}
+	result := &LineChunk{
+		Lines:  []Line{},
+		Offset: chunk.Offset,
+		Next:   chunk.Offset + int64(len(data)),
+		Size:   chunk.Size,
+	}
+	result.EOF = result.Next >= chunk.Size
+	for _, b := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(b) == 0 {
+			continue
+		}
+		line := decodeLine(b)
+		if stream != 0 && line.Stream != stream {
+			continue
+		}
+		if !line.Time.IsZero() && !start.IsZero() {
+			line.Offset = line.Time.Sub(start).Milliseconds()
+		}
+		result.Lines = append(result.Lines, line)
+	}
+	return result, nil
+}
+
+// TailRunLines 执行日志最后n行,stream不为0时只统计该输出流,同时返回已读取到的位置
+func TailRunLines(path string, n int, stream Stream, start time.Time) ([]Line, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	// 不筛选时从末尾读取,筛选时需要从头扫描
+	var r io.Reader = f
+	var next int64
+	if stream == 0 {
+		var buf bytes.Buffer
+		if next, err = TailLines(f, n, &buf); err != nil {
+			return nil, 0, err
+		}
+		r = &buf
+	}
+	lines := make([]Line, 0, n)
+	reader := bufio.NewReader(r)
+	for {
+		b, err := reader.ReadBytes('\n')
+		if stream != 0 {
+			next += int64(len(b))
+		}
+		if len(b) > 0 {
+			line := decodeLine(b)
+			if stream == 0 || line.Stream == stream {
+				if !line.Time.IsZero() && !start.IsZero() {
+					line.Offset = line.Time.Sub(start).Milliseconds()
+				}
+				lines = append(lines, line)
+				if len(lines) >= 2*n {
+					lines = append(lines[:0], lines[len(lines)-n:]...)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, next, nil
+}
+
+// LinesText 将多行输出合并为纯文本,任务保留的颜色等转义序列一并去掉
+func LinesText(lines []Line) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(StripANSI(line.Text))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
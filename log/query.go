@@ -0,0 +1,391 @@
+package xwlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"xuanwu/lib/pathutil"
+)
+
+const (
+	// 搜索时单行的最大长度,超过的部分截断
+	searchMaxLine = 1000
+	// 搜索时单行读取的上限,更长的行只搜索开头的部分
+	searchScanBuffer = 1024 * 1024
+	// 默认和最多返回的搜索结果
+	defaultSearchLimit = 200
+	maxSearchLimit     = 5000
+)
+
+// 日志文件类型
+const (
+	LogKindMain       = "main"        // main.log
+	LogKindMainBackup = "main_backup" // main.log轮转后的文件
+	LogKindTask       = "task"        // 任务日志,执行日志的兼容视图
+	LogKindLegacy     = "legacy"      // 旧版本的任务日志
+	LogKindOther      = "other"
+)
+
+// LogFile 日志目录下的文件
+type LogFile struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListLogFiles 列出日志目录下的文件,不包含执行日志目录
+func ListLogFiles() ([]LogFile, error) {
+	entries, err := os.ReadDir(pathutil.GetLogPath(""))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []LogFile{}, nil
+		}
+		return nil, err
+	}
+	files := []LogFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, LogFile{
+			Name:      entry.Name(),
+			Kind:      logKind(entry.Name()),
+			Size:      info.Size(),
+			UpdatedAt: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// logKind 根据文件名判断日志类型
+func logKind(name string) string {
+	switch {
+	case name == MAIN_LOG:
+		return LogKindMain
+	case isMainBackup(name):
+		return LogKindMainBackup
+	case strings.HasSuffix(name, LEGACY_SUFFIX):
+		return LogKindLegacy
+	case isCompatView(name):
+		return LogKindTask
+	}
+	return LogKindOther
+}
+
+// LogFilePath 日志目录下文件的路径,文件名不能包含路径
+func LogFilePath(name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("日志文件名错误: %s", name)
+	}
+	path := pathutil.GetLogPath(name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("日志文件不存在: %s", name)
+	}
+	return path, nil
+}
+
+// TaskRuns 任务执行日志的汇总
+type TaskRuns struct {
+	Task string   `json:"task"`
+	Runs int      `json:"runs"`
+	Size int64    `json:"size"`
+	Last *RunInfo `json:"last,omitempty"` // 最近一次执行
+}
+
+// ListTaskRuns 列出有执行日志的任务
+func ListTaskRuns() ([]TaskRuns, error) {
+	tasks, err := runTasks()
+	if err != nil {
+		return nil, err
+	}
+	list := []TaskRuns{}
+	for _, task := range tasks {
+		runs, err := ListRuns(task)
+		if err != nil || len(runs) == 0 {
+			continue
+		}
+		item := TaskRuns{Task: task, Runs: len(runs), Last: &runs[0]}
+		for _, run := range runs {
+			item.Size += run.Size
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// runTasks 有执行日志目录的任务名称
+func runTasks() ([]string, error) {
+	entries, err := os.ReadDir(pathutil.GetLogPath(RUNS_DIR))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var tasks []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			tasks = append(tasks, entry.Name())
+		}
+	}
+	sort.Strings(tasks)
+	return tasks, nil
+}
+
+// Chunk 按字节范围读取的日志内容
+type Chunk struct {
+	Content string `json:"content"`
+	Offset  int64  `json:"offset"` // 本次读取的起始位置
+	Next    int64  `json:"next"`   // 下次读取的起始位置
+	Size    int64  `json:"size"`   // 文件当前大小
+	EOF     bool   `json:"eof"`    // 是否已读取到文件末尾
+}
+
+// ReadRange 从offset开始读取最多limit字节,offset为负数时从文件末尾倒数
+func ReadRange(path string, offset, limit int64) (*Chunk, error) {
+	if strings.HasSuffix(path, ".gz") {
+		return nil, fmt.Errorf("压缩的日志文件请下载后查看")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if offset < 0 {
+		offset += size
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	if offset > size {
+		offset = size
+	}
+	if limit <= 0 || offset+limit > size {
+		limit = size - offset
+	}
+	buf := make([]byte, limit)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	// 范围的边界可能在多字节字符中间,去掉不完整的字符,避免返回的内容出现乱码
+	if offset > 0 {
+		skip := 0
+		for skip < len(buf) && skip < utf8.UTFMax-1 && !utf8.RuneStart(buf[skip]) {
+			skip++
+		}
+		buf = buf[skip:]
+		offset += int64(skip)
+	}
+	if offset+int64(len(buf)) < size {
+		for i := 1; i < utf8.UTFMax && i <= len(buf); i++ {
+			if utf8.RuneStart(buf[len(buf)-i]) {
+				if !utf8.FullRune(buf[len(buf)-i:]) {
+					buf = buf[:len(buf)-i]
+				}
+				break
+			}
+		}
+	}
+	return &Chunk{
+		Content: string(buf),
+		Offset:  offset,
+		Next:    offset + int64(len(buf)),
+		Size:    size,
+		EOF:     offset+int64(len(buf)) >= size,
+	}, nil
+}
+
+// TailLines 输出文件最后n行,n为0时输出全部,返回已读取到的位置
+func TailLines(f *os.File, n int, w io.Writer) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	// 从文件末尾按块向前查找换行符
+	start := int64(0)
+	if n > 0 {
+		const block = 64 * 1024
+		buf := make([]byte, block)
+		found := 0
+		pos := size
+		for pos > 0 && start == 0 {
+			readSize := int64(block)
+			if pos < readSize {
+				readSize = pos
+			}
+			pos -= readSize
+			if _, err := f.ReadAt(buf[:readSize], pos); err != nil {
+				return 0, err
+			}
+			for i := readSize - 1; i >= 0; i-- {
+				// 忽略文件末尾的换行符
+				if buf[i] != '\n' || pos+i == size-1 {
+					continue
+				}
+				found++
+				if found == n {
+					start = pos + i + 1
+					break
+				}
+			}
+		}
+	}
+
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	copied, err := io.Copy(w, io.LimitReader(f, size-start))
+	return start + copied, err
+}
+
+// SearchQuery 执行日志搜索条件
+type SearchQuery struct {
+	Pattern    string    // 搜索内容
+	Regex      bool      // Pattern为正则表达式
+	IgnoreCase bool      // 忽略大小写
+	Tasks      []string  // 搜索的任务,为空时搜索所有任务
+	Stream     Stream    // 只搜索该输出流,为0时不限制
+	Start      time.Time // 执行开始时间范围,为零时不限制
+	End        time.Time
+	Limit      int // 最多返回的结果数量
+}
+
+// SearchMatch 匹配的日志行
+type SearchMatch struct {
+	Task   string    `json:"task"`
+	RunID  string    `json:"run_id"`
+	Start  time.Time `json:"start"` // 执行开始时间
+	Line   int       `json:"line"`  // 行号,从1开始
+	Time   time.Time `json:"time"`  // 输出时间
+	Stream Stream    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// SearchRuns 在执行日志中搜索,按执行时间从新到旧返回,结果超过数量限制时truncated为true
+func SearchRuns(q SearchQuery) (matches []SearchMatch, truncated bool, err error) {
+	if q.Pattern == "" {
+		return nil, false, fmt.Errorf("搜索内容不能为空")
+	}
+	expr := q.Pattern
+	if !q.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if q.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, false, fmt.Errorf("正则表达式错误: %v", err)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchLimit
+	}
+	if q.Limit > maxSearchLimit {
+		q.Limit = maxSearchLimit
+	}
+
+	tasks := q.Tasks
+	if len(tasks) == 0 {
+		if tasks, err = runTasks(); err != nil {
+			return nil, false, err
+		}
+	}
+	var runs []RunInfo
+	for _, task := range tasks {
+		list, err := ListRuns(task)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, run := range list {
+			if !q.Start.IsZero() && run.Start.Before(q.Start) {
+				continue
+			}
+			if !q.End.IsZero() && run.Start.After(q.End) {
+				continue
+			}
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Start.After(runs[j].Start) })
+
+	matches = []SearchMatch{}
+	for _, run := range runs {
+		// 读取失败(如执行日志刚被清理)时保留已找到的结果
+		found, _ := searchFile(filepath.Join(runsDir(run.Task), run.ID+".log"), re, q.Stream, q.Limit-len(matches)+1)
+		for _, m := range found {
+			m.Task, m.RunID, m.Start = run.Task, run.ID, run.Start
+			matches = append(matches, m)
+		}
+		if len(matches) > q.Limit {
+			return matches[:q.Limit], true, nil
+		}
+	}
+	return matches, false, nil
+}
+
+// searchFile 在执行日志中查找内容匹配的行,最多返回limit个
+func searchFile(path string, re *regexp.Regexp, stream Stream, limit int) ([]SearchMatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var found []SearchMatch
+	reader := bufio.NewReaderSize(f, 64*1024)
+	var buf []byte
+	line := 0
+	for len(found) < limit {
+		// ReadSlice 在超长行时返回缓冲区大小的片段,超过上限的部分读取后丢弃
+		data, err := reader.ReadSlice('\n')
+		if room := searchScanBuffer - len(buf); room > 0 {
+			buf = append(buf, data[:min(len(data), room)]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return found, err
+		}
+		if len(buf) == 0 {
+			return found, nil
+		}
+		line++
+		l := decodeLine(buf)
+		buf = buf[:0]
+		// 带颜色的输出按去掉转义序列后的文本匹配
+		text := StripANSI(l.Text)
+		if stream != 0 && l.Stream != stream || !re.MatchString(text) {
+			continue
+		}
+		if len(text) > searchMaxLine {
+			text = strings.ToValidUTF8(text[:searchMaxLine], "") + "..."
+		}
+		found = append(found, SearchMatch{Line: line, Time: l.Time, Stream: l.Stream, Text: text})
+	}
+	return found, nil
+}
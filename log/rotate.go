@@ -0,0 +1,231 @@
+package xwlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 轮转后文件名中的时间格式
+const rotateTimeFormat = "2006-01-02T15-04-05"
+
+// RotateOptions 日志轮转配置
+type RotateOptions struct {
+	MaxSizeMB  int  // 单个文件大小上限,0表示不按大小轮转
+	Daily      bool // 跨天时轮转
+	MaxAgeDays int  // 轮转后的文件保留天数,0表示不按时间清理
+	MaxBackups int  // 轮转后的文件保留数量,0表示不限制
+	Compress   bool // 轮转后使用gzip压缩
+}
+
+// RotatingFile 按大小和日期轮转的日志文件
+type RotatingFile struct {
+	path     string
+	opts     RotateOptions
+	file     *os.File
+	size     int64
+	openDay  string // 文件打开时的日期
+	mu       sync.Mutex
+	compress sync.WaitGroup // 后台压缩
+}
+
+// OpenRotatingFile 打开日志文件,追加写入
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open 打开当前日志文件,调用方需持有锁
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openDay = info.ModTime().Format("2006-01-02")
+	if f.size == 0 {
+		f.openDay = time.Now().Format("2006-01-02")
+	}
+	return nil
+}
+
+// SetOptions 修改轮转配置,下次写入时生效
+func (f *RotatingFile) SetOptions(opts RotateOptions) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opts = opts
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.needRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			// 轮转失败时继续写入当前文件,避免丢失日志
+			fmt.Fprintf(os.Stderr, "日志轮转失败: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// needRotate 写入后是否超过大小上限或已跨天
+func (f *RotatingFile) needRotate(add int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSizeMB > 0 && f.size+add > int64(f.opts.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return f.opts.Daily && time.Now().Format("2006-01-02") != f.openDay
+}
+
+// Rotate 立即轮转
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+// rotate 关闭当前文件并重命名,然后打开新文件,调用方需持有锁
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().Format(rotateTimeFormat), ext)
+	renameErr := os.Rename(f.path, rotated)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	opts := f.opts
+	f.compress.Add(1)
+	go func() {
+		defer f.compress.Done()
+		if opts.Compress {
+			if err := gzipFile(rotated); err != nil {
+				log.Printf("压缩日志失败[%s]: %v", rotated, err)
+			}
+		}
+		f.prune(opts)
+	}()
+	return nil
+}
+
+// Close 关闭文件,等待后台压缩完成
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.compress.Wait()
+	return err
+}
+
+// Backups 返回轮转后的文件,按时间从新到旧
+func (f *RotatingFile) Backups() []string {
+	ext := filepath.Ext(f.path)
+	pattern := strings.TrimSuffix(f.path, ext) + "-*" + ext + "*"
+	matches, _ := filepath.Glob(pattern)
+	// 只保留文件名中是轮转时间的,避免误删名称相似的任务日志
+	var files []string
+	for _, name := range matches {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(name), ".gz"), ext)
+		stamp = strings.TrimPrefix(stamp, filepath.Base(strings.TrimSuffix(f.path, ext))+"-")
+		if _, err := time.Parse(rotateTimeFormat, stamp); err == nil {
+			files = append(files, name)
+		}
+	}
+	// 文件名中的时间可以直接按字符串排序
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files
+}
+
+// isMainBackup 是否是main.log轮转后的文件
+func isMainBackup(name string) bool {
+	stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".log")
+	if !strings.HasPrefix(stamp, "main-") {
+		return false
+	}
+	_, err := time.Parse(rotateTimeFormat, strings.TrimPrefix(stamp, "main-"))
+	return err == nil
+}
+
+// prune 删除超过保留数量或保留天数的轮转文件
+func (f *RotatingFile) prune(opts RotateOptions) {
+	cutoff := time.Now().AddDate(0, 0, -opts.MaxAgeDays)
+	for i, name := range f.Backups() {
+		expired := opts.MaxBackups > 0 && i >= opts.MaxBackups
+		if !expired && opts.MaxAgeDays > 0 {
+			if info, err := os.Stat(name); err == nil && info.ModTime().Before(cutoff) {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(name)
+		}
+	}
+}
+
+// gzipFile 压缩文件并删除原文件
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,495 @@
+package xwlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	RUNS_DIR      = "runs"        // 任务每次执行的日志目录,位于日志目录下
+	LEGACY_SUFFIX = ".legacy.log" // 旧版本任务日志改名后的后缀
+	// 执行ID中的时间格式,可以直接按字符串排序
+	runIDFormat = "20060102T150405.000"
+	// 兼容视图中包含的最近执行次数
+	compatViewRuns = 20
+	// 兼容视图中每次执行前的时间头格式,与旧版任务日志一致
+	compatTimeFormat = "2006-01-02 15:04:05"
+
+	defaultMaxRuns    = 100
+	defaultMaxTotalMB = 50
+)
+
+// 执行结果
+const (
+	RunSuccess = "success"
+	RunWarning = "warning"
+	RunFailure = "failure"
+)
+
+// RunResult 执行结果的分类
+type RunResult struct {
+	Status    string // success/warning/failure
+	ExitCode  int    // 进程退出码,未能获取时为-1
+	Error     string // 执行出错的原因
+	Reason    string // 判定为警告或失败的规则
+	Truncated bool   // 输出超过限制被截断
+}
+
+// RunInfo 一次任务执行的信息,保存在执行日志旁的 <id>.json 中
+type RunInfo struct {
+	ID        string    `json:"id"`
+	Task      string    `json:"task"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  int64     `json:"duration_ms"`
+	Success   bool      `json:"success"` // 结果不是失败
+	Status    string    `json:"status"`  // 执行结果,正在执行时为空
+	ExitCode  int       `json:"exit_code"`
+	Error     string    `json:"error,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Truncated bool      `json:"truncated,omitempty"` // 输出超过限制被截断
+	Size      int64     `json:"size"`
+	Running   bool      `json:"running,omitempty"`
+}
+
+// RunRetention 执行日志保留策略,按任务分别计算,0表示不限制
+type RunRetention struct {
+	MaxAgeDays int // 保留天数
+	MaxRuns    int // 保留的执行次数
+	MaxTotalMB int // 所有执行日志的总大小
+}
+
+var (
+	runRetention = RunRetention{MaxAgeDays: 7, MaxRuns: defaultMaxRuns, MaxTotalMB: defaultMaxTotalMB}
+	runsLock     sync.Mutex // 保护保留策略、执行序号、正在执行的记录和清理锁
+	runSeq       int
+	activeRuns   = map[string]*RunLog{}     // task/id -> 正在执行的日志
+	pruneLocks   = map[string]*sync.Mutex{} // 任务名称 -> 清理和生成兼容视图时持有的锁,不影响其他任务
+)
+
+// pruneLock 任务的清理锁
+func pruneLock(task string) *sync.Mutex {
+	runsLock.Lock()
+	defer runsLock.Unlock()
+	lock, ok := pruneLocks[task]
+	if !ok {
+		lock = &sync.Mutex{}
+		pruneLocks[task] = lock
+	}
+	return lock
+}
+
+// ParseRunRetention 从配置中解析执行日志保留策略,保留天数未配置时使用 log_clean_days
+func ParseRunRetention(cfg gjson.Result) RunRetention {
+	r := RunRetention{MaxAgeDays: 7, MaxRuns: defaultMaxRuns, MaxTotalMB: defaultMaxTotalMB}
+	if days := cfg.Get("log_clean_days").Int(); days > 0 {
+		r.MaxAgeDays = int(days)
+	}
+	if v := cfg.Get("task_log.max_age_days"); v.Exists() {
+		r.MaxAgeDays = int(v.Int())
+	}
+	if v := cfg.Get("task_log.max_runs"); v.Exists() {
+		r.MaxRuns = int(v.Int())
+	}
+	if v := cfg.Get("task_log.max_total_mb"); v.Exists() {
+		r.MaxTotalMB = int(v.Int())
+	}
+	return r
+}
+
+// SetRunRetention 修改执行日志保留策略,下次执行结束或定时清理时生效
+func SetRunRetention(r RunRetention) {
+	runsLock.Lock()
+	runRetention = r
+	runsLock.Unlock()
+}
+
+// GetRun 读取一次执行的信息
+func GetRun(task, id string) (RunInfo, error) {
+	if _, err := RunLogPath(task, id); err != nil {
+		return RunInfo{}, err
+	}
+	return readRunInfo(task, id), nil
+}
+
+// GetRunRetention 当前执行日志保留策略
+func GetRunRetention() RunRetention {
+	runsLock.Lock()
+	defer runsLock.Unlock()
+	return runRetention
+}
+
+// RunLog 一次任务执行的日志文件
+type RunLog struct {
+	info RunInfo
+	file *os.File
+	mu   sync.Mutex
+}
+
+// runsDir 任务执行日志目录
+func runsDir(task string) string {
+	return pathutil.GetLogPath(filepath.Join(RUNS_DIR, task))
+}
+
+// validName 任务名称和执行ID不能包含路径
+func validName(name string) bool {
+	return name != "" && name != "." && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+// StartRun 创建一次执行的日志文件,执行结束后需要调用 Finish
+func StartRun(task string) (*RunLog, error) {
+	if !validName(task) {
+		return nil, fmt.Errorf("任务名称不能作为日志文件名: %s", task)
+	}
+	dir := runsDir(task)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// 旧版本追加写入的任务日志会被兼容视图覆盖,第一次执行前改名保留
+		legacy := pathutil.GetLogPath(task + ".log")
+		if _, err := os.Stat(legacy); err == nil {
+			if err := os.Rename(legacy, pathutil.GetLogPath(task+LEGACY_SUFFIX)); err == nil {
+				Infof("旧版任务日志已改名为 %s%s", task, LEGACY_SUFFIX)
+			}
+		}
+	}
+	if err := pathutil.EnsureDir(dir); err != nil {
+		return nil, fmt.Errorf("创建执行日志目录失败: %v", err)
+	}
+
+	start := time.Now()
+	runsLock.Lock()
+	runSeq = (runSeq + 1) % 1000
+	id := fmt.Sprintf("%s-%03d", start.Format(runIDFormat), runSeq)
+	runsLock.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(dir, id+".log"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("创建执行日志失败: %v", err)
+	}
+	run := &RunLog{
+		info: RunInfo{ID: id, Task: task, Start: start, Running: true},
+		file: file,
+	}
+	runsLock.Lock()
+	activeRuns[task+"/"+id] = run
+	runsLock.Unlock()
+	return run, nil
+}
+
+// ID 执行ID
+func (r *RunLog) ID() string {
+	return r.info.ID
+}
+
+// WriteLine 记录一行输出及其输出流和时间
+func (r *RunLog) WriteLine(stream Stream, text string) error {
+	return r.WriteLineAt(time.Now(), stream, text)
+}
+
+// WriteLineAt 按指定的输出时间记录一行,用于先缓存后写入的输出
+func (r *RunLog) WriteLineAt(t time.Time, stream Stream, text string) error {
+	line := FormatLine(t, stream, text)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	n, err := r.file.Write(line)
+	r.info.Size += int64(n)
+	return err
+}
+
+// Write 按行记录为系统信息,用于 log.Logger 等
+func (r *RunLog) Write(p []byte) (int, error) {
+	for _, text := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if err := r.WriteLine(StreamSystem, text); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Finish 关闭日志并记录执行结果,然后按保留策略清理该任务的旧日志并更新兼容视图
+func (r *RunLog) Finish(result RunResult) error {
+	r.mu.Lock()
+	if r.file == nil {
+		r.mu.Unlock()
+		return nil
+	}
+	closeErr := r.file.Close()
+	r.file = nil
+	r.info.End = time.Now()
+	r.info.Duration = r.info.End.Sub(r.info.Start).Milliseconds()
+	r.info.Running = false
+	r.info.Status = result.Status
+	r.info.Success = result.Status != RunFailure
+	r.info.ExitCode = result.ExitCode
+	r.info.Error = result.Error
+	r.info.Reason = result.Reason
+	r.info.Truncated = result.Truncated
+	info := r.info
+	r.mu.Unlock()
+
+	runsLock.Lock()
+	delete(activeRuns, info.Task+"/"+info.ID)
+	retention := runRetention
+	runsLock.Unlock()
+
+	if err := writeRunInfo(info); err != nil {
+		return fmt.Errorf("保存执行信息失败: %v", err)
+	}
+	if _, err := PruneRuns(info.Task, retention); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// CloseRuns 关闭所有未结束的执行日志,记录为失败,用于程序退出
+func CloseRuns(reason string) {
+	runsLock.Lock()
+	runs := make([]*RunLog, 0, len(activeRuns))
+	for _, run := range activeRuns {
+		runs = append(runs, run)
+	}
+	runsLock.Unlock()
+	for _, run := range runs {
+		run.Finish(RunResult{Status: RunFailure, ExitCode: -1, Error: reason})
+	}
+}
+
+// ListRuns 返回任务的执行记录,按时间从新到旧。没有信息文件的执行视为正在执行,
+// 命令行等其他进程也可以据此判断
+func ListRuns(task string) ([]RunInfo, error) {
+	if !validName(task) {
+		return nil, fmt.Errorf("任务名称错误: %s", task)
+	}
+	entries, err := os.ReadDir(runsDir(task))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RunInfo{}, nil
+		}
+		return nil, err
+	}
+
+	runs := []RunInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		runs = append(runs, readRunInfo(task, strings.TrimSuffix(name, ".log")))
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs, nil
+}
+
+// readRunInfo 读取执行信息,没有信息文件时根据日志文件推断
+func readRunInfo(task, id string) RunInfo {
+	dir := runsDir(task)
+	info := RunInfo{ID: id, Task: task}
+	if data, err := os.ReadFile(filepath.Join(dir, id+".json")); err == nil && json.Unmarshal(data, &info) == nil {
+		// 没有结果分类的旧记录按是否成功判断
+		if info.Status == "" {
+			info.Status = RunSuccess
+			if !info.Success {
+				info.Status, info.ExitCode = RunFailure, -1
+			}
+		}
+		return info
+	}
+	if start, err := time.ParseInLocation(runIDFormat, strings.SplitN(id, "-", 2)[0], time.Local); err == nil {
+		info.Start = start
+	}
+	if stat, err := os.Stat(filepath.Join(dir, id+".log")); err == nil {
+		info.Size = stat.Size()
+	}
+	info.Running = true
+	return info
+}
+
+// writeRunInfo 保存执行信息
+func writeRunInfo(info RunInfo) error {
+	data, _ := json.MarshalIndent(info, "", "  ")
+	return os.WriteFile(filepath.Join(runsDir(info.Task), info.ID+".json"), data, 0644)
+}
+
+// RecoverRuns 将上次程序退出时未结束的执行标记为中断,在启动调度前调用
+func RecoverRuns() {
+	entries, err := os.ReadDir(pathutil.GetLogPath(RUNS_DIR))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		runs, err := ListRuns(entry.Name())
+		if err != nil {
+			continue
+		}
+		for _, info := range runs {
+			if !info.Running {
+				continue
+			}
+			info.Running = false
+			info.Status = RunFailure
+			info.ExitCode = -1
+			info.Error = "程序退出时执行未结束"
+			if stat, err := os.Stat(filepath.Join(runsDir(info.Task), info.ID+".log")); err == nil {
+				info.End = stat.ModTime()
+				info.Duration = info.End.Sub(info.Start).Milliseconds()
+			}
+			writeRunInfo(info)
+		}
+	}
+}
+
+// RunLogPath 执行日志文件路径
+func RunLogPath(task, id string) (string, error) {
+	if !validName(task) || !validName(id) {
+		return "", fmt.Errorf("任务名称或执行ID错误")
+	}
+	path := filepath.Join(runsDir(task), id+".log")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("执行日志不存在: %s", id)
+	}
+	return path, nil
+}
+
+// PruneRuns 按保留策略删除任务的旧执行日志,正在执行的日志不会删除,然后更新兼容视图
+func PruneRuns(task string, retention RunRetention) (CleanStat, error) {
+	stat := CleanStat{File: filepath.ToSlash(filepath.Join(RUNS_DIR, task))}
+	lock := pruneLock(task)
+	lock.Lock()
+	defer lock.Unlock()
+
+	runs, err := ListRuns(task)
+	if err != nil {
+		return stat, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retention.MaxAgeDays)
+	maxTotal := int64(retention.MaxTotalMB) * 1024 * 1024
+	var total int64
+	kept, running := 0, 0
+	var finished []RunInfo
+	for _, run := range runs {
+		stat.Before += run.Size
+		if run.Running {
+			running++
+			continue
+		}
+		expired := retention.MaxRuns > 0 && kept >= retention.MaxRuns
+		if !expired && retention.MaxAgeDays > 0 && run.Start.Before(cutoff) {
+			expired = true
+		}
+		// 至少保留最近一次执行,即使它本身超过了总大小
+		if !expired && maxTotal > 0 && kept > 0 && total+run.Size > maxTotal {
+			expired = true
+		}
+		if expired {
+			removeRun(task, run.ID)
+			stat.BlocksRemoved++
+			stat.Freed += run.Size
+			continue
+		}
+		kept++
+		total += run.Size
+		finished = append(finished, run)
+	}
+	stat.BlocksKept = kept + running
+	stat.After = stat.Before - stat.Freed
+	if len(finished) == 0 {
+		// 没有执行记录时删除目录和兼容视图
+		if running == 0 {
+			os.Remove(runsDir(task))
+		}
+		os.Remove(pathutil.GetLogPath(task + ".log"))
+		return stat, nil
+	}
+	return stat, writeCompatView(task, finished)
+}
+
+// removeRun 删除一次执行的日志和信息文件
+func removeRun(task, id string) {
+	dir := runsDir(task)
+	os.Remove(filepath.Join(dir, id+".log"))
+	os.Remove(filepath.Join(dir, id+".json"))
+}
+
+// CleanRuns 按当前保留策略清理所有任务的执行日志
+func CleanRuns() ([]CleanStat, error) {
+	tasks, err := runTasks()
+	if err != nil {
+		return nil, fmt.Errorf("读取执行日志目录失败: %v", err)
+	}
+	retention := GetRunRetention()
+	var stats []CleanStat
+	for _, task := range tasks {
+		stat, err := PruneRuns(task, retention)
+		if err != nil {
+			stat.Error = err.Error()
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// isCompatView 日志目录下的文件是否是执行日志的兼容视图
+func isCompatView(name string) bool {
+	if !strings.HasSuffix(name, ".log") || strings.HasSuffix(name, LEGACY_SUFFIX) {
+		return false
+	}
+	info, err := os.Stat(runsDir(strings.TrimSuffix(name, ".log")))
+	return err == nil && info.IsDir()
+}
+
+// writeCompatView 将最近几次已结束的执行合并为 <任务名称>.log,格式与旧版任务日志相同(只有输出内容),
+// 调用方需持有任务的清理锁。逐个执行日志直接写入临时文件再重命名,读取方不会看到写了一半的内容
+func writeCompatView(task string, runs []RunInfo) error {
+	if len(runs) > compatViewRuns {
+		runs = runs[:compatViewRuns]
+	}
+	path := pathutil.GetLogPath(task + ".log")
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("写入任务日志失败: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	for i := len(runs) - 1; i >= 0 && err == nil; i-- {
+		if _, err = fmt.Fprintf(w, "\n%s\n", runs[i].Start.Format(compatTimeFormat)); err != nil {
+			break
+		}
+		run, openErr := os.Open(filepath.Join(runsDir(task), runs[i].ID+".log"))
+		if openErr != nil {
+			continue
+		}
+		_, err = DecodeText(run, w)
+		run.Close()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入任务日志失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入任务日志失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package xwlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	MAIN_LOG          = "main.log"
+	defaultMaxSizeMB  = 10
+	defaultMaxAgeDays = 30
+	defaultMaxBackups = 10
+	textTimeFormat    = "2006-01-02 15:04:05.000"
+	defaultComponent  = "main"
+)
+
+// 访问日志格式
+const (
+	AccessFormatText     = "text"     // 方法 路径 状态码 耗时 等字段
+	AccessFormatCombined = "combined" // Apache/Nginx combined 格式
+	AccessFormatOff      = "off"      // 不记录
+)
+
+// Options 系统日志配置,对应配置文件中的 log 字段
+type Options struct {
+	Format       string           // 输出格式 text/json
+	Components   map[string]Level // 各组件的日志级别,未配置的使用默认级别
+	AccessFormat string           // 访问日志格式 text/combined/off
+	Rotate       RotateOptions    // 轮转配置
+	Journal      bool             // 同时输出到journald
+}
+
+// ParseOptions 从配置中解析系统日志配置,默认级别由 log_level 设置
+func ParseOptions(cfg gjson.Result) Options {
+	opts := Options{
+		Format:       strings.ToLower(cfg.Get("log.format").String()),
+		Components:   map[string]Level{},
+		AccessFormat: strings.ToLower(cfg.Get("log.access_format").String()),
+		Rotate: RotateOptions{
+			MaxSizeMB:  defaultMaxSizeMB,
+			Daily:      true,
+			MaxAgeDays: defaultMaxAgeDays,
+			MaxBackups: defaultMaxBackups,
+			Compress:   true,
+		},
+	}
+	if opts.Format != "json" {
+		opts.Format = "text"
+	}
+	if opts.AccessFormat == "" {
+		opts.AccessFormat = AccessFormatText
+	}
+	cfg.Get("log.components").ForEach(func(key, value gjson.Result) bool {
+		if l, err := ParseLevel(value.String()); err == nil {
+			opts.Components[key.String()] = l
+		}
+		return true
+	})
+	if v := cfg.Get("log.max_size_mb"); v.Exists() {
+		opts.Rotate.MaxSizeMB = int(v.Int())
+	}
+	if v := cfg.Get("log.max_age_days"); v.Exists() {
+		opts.Rotate.MaxAgeDays = int(v.Int())
+	}
+	if v := cfg.Get("log.max_backups"); v.Exists() {
+		opts.Rotate.MaxBackups = int(v.Int())
+	}
+	if v := cfg.Get("log.daily"); v.Exists() {
+		opts.Rotate.Daily = v.Bool()
+	}
+	if v := cfg.Get("log.compress"); v.Exists() {
+		opts.Rotate.Compress = v.Bool()
+	}
+	return opts
+}
+
+// system 系统日志的输出
+var system = struct {
+	sync.RWMutex
+	opts    Options
+	file    *RotatingFile
+	journal io.Writer
+	out     sync.Mutex // 保证一条日志完整写入
+}{
+	opts: Options{Format: "text", AccessFormat: AccessFormatText},
+}
+
+// InitSystem 打开main.log并将标准库log输出到系统日志,返回的Closer在退出时关闭
+func InitSystem(opts Options) (io.Closer, error) {
+	file, err := OpenRotatingFile(pathutil.GetLogPath(MAIN_LOG), opts.Rotate)
+	if err != nil {
+		return nil, err
+	}
+	system.Lock()
+	system.file = file
+	system.opts = opts
+	if opts.Journal {
+		system.journal = os.Stderr
+	}
+	system.Unlock()
+
+	log.SetFlags(0)
+	log.SetOutput(Component(defaultComponent).Writer(LevelInfo))
+	return file, nil
+}
+
+// Configure 修改日志配置,用于配置重新加载
+func Configure(opts Options) {
+	system.Lock()
+	defer system.Unlock()
+	opts.Journal = system.opts.Journal
+	system.opts = opts
+	if system.file != nil {
+		system.file.SetOptions(opts.Rotate)
+	}
+}
+
+// AccessFormat 访问日志格式
+func AccessFormat() string {
+	system.RLock()
+	defer system.RUnlock()
+	return system.opts.AccessFormat
+}
+
+// Logger 组件日志
+type Logger struct {
+	component string
+}
+
+// Component 获取组件日志,如 cron、gin、access
+func Component(name string) *Logger {
+	return &Logger{component: name}
+}
+
+// Enabled 该级别的日志是否输出
+func (l *Logger) Enabled(level Level) bool {
+	system.RLock()
+	min, ok := system.opts.Components[l.component]
+	system.RUnlock()
+	if !ok {
+		min = GetLevel()
+	}
+	return level >= min
+}
+
+// Log 输出一条日志,fields为附加字段
+func (l *Logger) Log(level Level, msg string, fields map[string]interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	write(time.Now(), level, l.component, strings.TrimRight(msg, "\n"), fields)
+}
+
+// Debugf 调试日志
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.Log(LevelDebug, fmt.Sprintf(format, args...), nil)
+}
+
+// Infof 普通日志
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.Log(LevelInfo, fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf 警告日志
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.Log(LevelWarn, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf 错误日志
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.Log(LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+// levelWriter 将写入的内容作为指定级别的日志
+type levelWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	w.logger.Log(w.level, string(p), nil)
+	return len(p), nil
+}
+
+// Writer 返回按指定级别写入的io.Writer,用于标准库log和gin
+func (l *Logger) Writer(level Level) io.Writer {
+	return levelWriter{logger: l, level: level}
+}
+
+// write 格式化并写入main.log,未初始化时输出到标准错误
+func write(t time.Time, level Level, component, msg string, fields map[string]interface{}) {
+	system.RLock()
+	format := system.opts.Format
+	var out io.Writer = os.Stderr
+	if system.file != nil {
+		out = system.file
+	}
+	journal := system.journal
+	system.RUnlock()
+
+	var line []byte
+	if format == "json" {
+		rec := map[string]interface{}{}
+		for k, v := range fields {
+			rec[k] = v
+		}
+		rec["time"] = t.Format(time.RFC3339Nano)
+		rec["level"] = level.String()
+		rec["component"] = component
+		rec["msg"] = msg
+		line, _ = json.Marshal(rec)
+		line = append(line, '\n')
+	} else {
+		prefix := fmt.Sprintf("%s %-5s [%s] ", t.Format(textTimeFormat), strings.ToUpper(level.String()), component)
+		line = prefixLines(prefix, msg+formatFields(fields))
+	}
+
+	system.out.Lock()
+	defer system.out.Unlock()
+	out.Write(line)
+	if journal != nil {
+		// journald会自己记录时间,只输出优先级、组件和内容
+		journal.Write(prefixLines(fmt.Sprintf("%s[%s] ", journalPriority[level], component), msg+formatFields(fields)))
+	}
+}
+
+// prefixLines 多行内容的每一行都加上前缀,journald按行记录,每行都需要优先级,
+// main.log按行过滤时也不会丢失时间、级别和组件
+func prefixLines(prefix, text string) []byte {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// formatFields 文本格式的附加字段 key=value
+func formatFields(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := fmt.Sprint(fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\r\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
@@ -1,40 +1,73 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"xuanwu/cli"
 	"xuanwu/config"
 	serve "xuanwu/gin"
+	"xuanwu/lib/systemd"
 	xwlog "xuanwu/log"
 	"xuanwu/xuanwu"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	httpShutdownTimeout  = 10 * time.Second // 关闭web服务的等待时间
+	defaultShutdownGrace = 30 * time.Second // 默认等待任务结束的时间
+	killWaitTimeout      = 5 * time.Second  // 结束进程后等待输出读取完成的时间
+	configWatchInterval  = 2 * time.Second  // 检查配置文件变化的间隔
 )
 
 // 添加Windows命令行参数
 var hideWindow = flag.Bool("hide", false, "在Windows平台下隐藏命令提示符窗口")
 
-func init() {
-	if !config.IsWindows { //windows上设置时区会报错,不设置也会正常显示,linux日志时间会差8小时
-		TIME_LOCATION, err := time.LoadLocation("Asia/Shanghai")
-		if err != nil {
-			log.Printf("time时区设置失败")
-			panic(err)
-		}
-		time.Local = TIME_LOCATION
-	}
-}
+// 验证设备丢失时,通过命令行关闭两步验证
+var disable2FA = flag.Bool("disable-2fa", false, "关闭面板登录的两步验证后退出,同 user disable-2fa 命令")
 
 func main() {
 	// 监听系统信号
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "用法: %s [参数] [命令]\n\n参数:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), "\n"+cli.Usage)
+	}
 	flag.Parse()
+	if err := applyPathOptions(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	registerOverrides()
+
+	// 管理命令,如 xuanwu task list
+	if flag.NArg() > 0 {
+		// 使用与服务相同的时区,配置文件有误时不影响校验等命令
+		raw, _ := os.ReadFile(config.ConfigPath())
+		applyTimezone(config.ApplyOverrides(gjson.ParseBytes(raw)))
+		os.Exit(cli.Run(flag.Args()))
+	}
+
+	if *disable2FA {
+		if err := serve.DisableTwoFactor(config.Change{User: "cli", Reason: "命令行关闭两步验证"}); err != nil {
+			fmt.Println("关闭两步验证失败:", err)
+			os.Exit(1)
+		}
+		fmt.Println("两步验证已关闭")
+		return
+	}
 
 	// Windows平台特定逻辑
 	if config.IsWindows && *hideWindow {
@@ -42,31 +75,162 @@ func main() {
 	}
 
 	//初始化日志文件
-	_, Writer := xwlog.LogInit("main.log")
-	log.SetOutput(Writer) // 设置默认logger
+	// 先使用默认配置,读取配置文件后再应用日志配置
+	logOpts := xwlog.ParseOptions(gjson.Result{})
+	logOpts.Journal = systemd.JournalStream() // 由systemd启动时同时输出到journald
+	Writer, err := xwlog.InitSystem(logOpts)
+	if err != nil {
+		fmt.Println("初始化日志失败:", err)
+		os.Exit(1)
+	}
+	defer Writer.Close()
 
 	// 退出时记录日志
 	defer func() {
 		log.Println("玄武系统退出")
 	}()
 
+	// 配置文件损坏时从备份恢复
+	if err := config.RecoverConfig(); err != nil {
+		fmt.Println("配置文件读取失败:", err)
+		return
+	}
+	// 升级旧版本配置结构
+	if _, err := config.Migrate(); err != nil {
+		log.Printf("配置文件升级失败: %v", err)
+		fmt.Println("配置文件升级失败:", err)
+		return
+	}
+
 	cfg, err := config.ReadConfigFileToJson()
 	if err != nil {
 		log.Println("读取配置文件出错")
 		return
 	}
+	// 命令行和环境变量覆盖配置文件中的值
+	cfg = config.ApplyOverrides(cfg)
+	if err := config.ValidateConfig([]byte(cfg.Raw)); err != nil {
+		log.Println(err)
+		fmt.Println(err)
+		return
+	}
+	if fields := config.UnknownFields([]byte(cfg.Raw)); len(fields) > 0 {
+		xwlog.Warnf("配置中存在未知字段,已忽略: %s", strings.Join(fields, ", "))
+	}
+	if err := applyTimezone(cfg); err != nil {
+		log.Println(err)
+		fmt.Println(err)
+		return
+	}
+	applyLogOptions(cfg)
+	applyTaskOutputOptions(cfg)
+	xuanwu.UpdateLogCleanDays(int(cfg.Get("log_clean_days").Int()))
+	if keys := config.Overrides(); len(keys) > 0 {
+		log.Printf("以下配置项由命令行参数或环境变量指定: %v", keys)
+	}
 
 	// 初始化全局配置
 	serve.InitGlobalConfig()
 
-	//初始化web服务 传递端口
-	go serve.InitApi(cfg, nil)
-	//初始化定时任务
-	go xuanwu.CronInit(cfg)
+	//初始化web服务 传递端口,开始监听后才通知systemd启动完成
+	listenResult := make(chan error, 1)
+	go serve.InitApi(cfg, nil, func(err error) { listenResult <- err })
+	//初始化定时任务,上次退出时未结束的执行记录为中断
+	xwlog.RecoverRuns()
+	xuanwu.CronInit(cfg)
+
+	// 配置重新加载后刷新web服务缓存的配置
+	xuanwu.OnConfigReload(func(cfg gjson.Result) {
+		serve.InitGlobalConfig()
+		cfg = config.ApplyOverrides(cfg)
+		applyLogOptions(cfg)
+		applyTaskOutputOptions(cfg)
+	})
+	// 监听配置文件变化
+	if !cfg.Get("config_watch").Exists() || cfg.Get("config_watch").Bool() {
+		go xuanwu.WatchConfig(configWatchInterval)
+	}
+	// 收到SIGHUP时重新加载配置
+	var ready atomic.Bool // 已通知systemd启动完成
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			systemd.Reloading()
+			if _, err := xuanwu.Reload(); err != nil {
+				log.Printf("重新加载配置失败,继续使用当前配置: %v", err)
+			}
+			if ready.Load() {
+				systemd.Ready("玄武运行中")
+			}
+		}
+	}()
 
 	fmt.Println(time.Now())
 	fmt.Println("玄武启动，按 Ctrl+C 退出")
 	log.Println("玄武系统启动")
+	// web服务开始监听后通知systemd启动完成,并按WatchdogSec定期检查调度和web服务后通知。
+	// 监听失败时不通知,由systemd按启动超时处理
+	if err := <-listenResult; err != nil {
+		systemd.Notify("STATUS=web服务启动失败: " + err.Error())
+	} else if ok, err := systemd.Ready("玄武运行中"); err != nil {
+		log.Printf("通知systemd失败: %v", err)
+	} else if ok {
+		ready.Store(true)
+		if systemd.StartWatchdog(aliveCheck) {
+			log.Printf("已开启systemd看门狗,超时时间%v", systemd.WatchdogInterval())
+		}
+	}
 
 	<-sigChan
+	shutdown(cfg)
+}
+
+// aliveCheck 看门狗的存活检查,调度循环和web服务都能响应时通过
+func aliveCheck(timeout time.Duration) error {
+	if err := xuanwu.SchedulerAlive(timeout); err != nil {
+		return err
+	}
+	return serve.Alive(timeout)
+}
+
+// shutdown 按顺序关闭: 停止调度 -> 关闭web服务 -> 等待任务结束 -> 结束剩余进程 -> 关闭日志
+func shutdown(cfg gjson.Result) {
+	fmt.Println("玄武正在关闭，再次按 Ctrl+C 强制退出")
+	systemd.Stopping()
+	log.Println("玄武系统开始关闭")
+
+	// 再次收到信号时强制退出
+	forceChan := make(chan os.Signal, 1)
+	signal.Notify(forceChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-forceChan
+		log.Println("收到强制退出信号")
+		xuanwu.KillRunning()
+		os.Exit(1)
+	}()
+
+	// 停止定时调度,不再触发新任务
+	xuanwu.StopScheduler()
+
+	// 关闭web服务,等待处理中的请求完成
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	if err := serve.Shutdown(ctx); err != nil {
+		log.Printf("web服务关闭超时: %v", err)
+	}
+	cancel()
+
+	// 等待正在执行的任务结束
+	grace := defaultShutdownGrace
+	if secs := cfg.Get("shutdown_grace_seconds").Int(); secs > 0 {
+		grace = time.Duration(secs) * time.Second
+	}
+	if !xuanwu.WaitRunning(grace) {
+		log.Printf("等待任务结束超时(%v),结束剩余任务进程", grace)
+		xuanwu.KillRunning()
+		xuanwu.WaitRunning(killWaitTimeout)
+	}
+
+	// 关闭任务日志
+	xuanwu.CloseTaskLogs()
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	_ "time/tzdata" // 内置时区数据,精简容器镜像中没有系统时区文件
+
+	"xuanwu/config"
+	"xuanwu/lib/pathutil"
+	xwlog "xuanwu/log"
+	"xuanwu/xuanwu"
+
+	"github.com/tidwall/gjson"
+)
+
+// 默认时区,linux上不设置时日志时间会差8小时
+const defaultTimezone = "Asia/Shanghai"
+
+// 核心配置的命令行参数,未指定时读取对应的环境变量,优先级: 命令行 > 环境变量 > 配置文件
+var (
+	dataDirFlag  = flag.String("data-dir", "", "数据目录,默认为程序目录下的data [XUANWU_DATA_DIR]")
+	configFlag   = flag.String("config", "", "配置文件路径,默认为数据目录下的config.json [XUANWU_CONFIG]")
+	portFlag     = flag.String("port", "", "web服务端口 [XUANWU_PORT]")
+	listenFlag   = flag.String("listen", "", "监听地址,支持 unix:/path/to.sock [XUANWU_LISTEN]")
+	logLevelFlag = flag.String("log-level", "", "日志级别 debug/info/warn/error [XUANWU_LOG_LEVEL]")
+	tzFlag       = flag.String("tz", "", "时区,如 Asia/Shanghai、UTC、Local [XUANWU_TZ]")
+)
+
+// flagOrEnv 命令行参数为空时使用环境变量
+func flagOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// applyPathOptions 设置数据目录和配置文件路径,需要在初始化日志和读取配置之前调用
+func applyPathOptions() error {
+	if dir := flagOrEnv(*dataDirFlag, "XUANWU_DATA_DIR"); dir != "" {
+		if err := pathutil.SetDataDir(dir); err != nil {
+			return fmt.Errorf("数据目录设置失败: %v", err)
+		}
+	}
+	if path := flagOrEnv(*configFlag, "XUANWU_CONFIG"); path != "" {
+		if err := config.SetConfigPath(path); err != nil {
+			return fmt.Errorf("配置文件路径设置失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// registerOverrides 将命令行和环境变量中的配置项注册为运行时覆盖,不会写入配置文件
+func registerOverrides() {
+	options := []struct {
+		key   string
+		value string
+	}{
+		{"port", flagOrEnv(*portFlag, "XUANWU_PORT")},
+		{"listen", flagOrEnv(*listenFlag, "XUANWU_LISTEN")},
+		{"log_level", flagOrEnv(*logLevelFlag, "XUANWU_LOG_LEVEL")},
+		{"timezone", flagOrEnv(*tzFlag, "XUANWU_TZ")},
+	}
+	for _, opt := range options {
+		if opt.value != "" {
+			config.SetOverride(opt.key, opt.value)
+		}
+	}
+}
+
+// applyLogOptions 设置日志级别、格式、轮转配置和执行日志保留策略,日志级别未配置时为info
+func applyLogOptions(cfg gjson.Result) {
+	xwlog.Configure(xwlog.ParseOptions(cfg))
+	xwlog.SetRunRetention(xwlog.ParseRunRetention(cfg))
+	name := cfg.Get("log_level").String()
+	if name == "" {
+		name = "info"
+	}
+	level, err := xwlog.ParseLevel(name)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	xwlog.SetLevel(level)
+}
+
+// applyTaskOutputOptions 设置任务输出的默认限制和隐藏规则,下次执行任务时生效
+func applyTaskOutputOptions(cfg gjson.Result) {
+	xuanwu.SetOutputLimit(xuanwu.ParseOutputLimit(cfg))
+	xuanwu.SetMaskConfig(xuanwu.ParseMaskConfig(cfg))
+}
+
+// applyTimezone 设置时区,只在启动时调用
+func applyTimezone(cfg gjson.Result) error {
+	name := cfg.Get("timezone").String()
+	if name == "" {
+		if config.IsWindows { //windows上设置时区会报错,不设置也会正常显示
+			return nil
+		}
+		name = defaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("时区设置失败[%s]: %v", name, err)
+	}
+	time.Local = loc
+	return nil
+}
@@ -0,0 +1,37 @@
+package xuanwu
+
+import (
+	"strings"
+	"xuanwu/config"
+	xwlog "xuanwu/log"
+)
+
+// taskAnsiMode 任务输出中转义序列的处理方式,未配置时去掉
+func taskAnsiMode(task TaskInfo) string {
+	if task.Ansi == "" {
+		return config.AnsiStrip
+	}
+	return task.Ansi
+}
+
+// convertANSI 按处理方式转换一行输出。去掉或保留颜色时,进度条等用\r覆盖的内容只保留最后显示的部分
+func convertANSI(mode, text string) string {
+	if mode == config.AnsiPreserve {
+		return text
+	}
+	if i := strings.LastIndexByte(text, '\r'); i >= 0 && i < len(text)-1 {
+		text = text[i+1:]
+	}
+	if mode == config.AnsiHTML {
+		return xwlog.KeepSGR(text)
+	}
+	return xwlog.StripANSI(text)
+}
+
+// colorEnv 要求或禁止任务输出颜色的环境变量
+func colorEnv(color bool) []string {
+	if color {
+		return []string{"TERM=xterm-256color", "FORCE_COLOR=1", "CLICOLOR_FORCE=1", "PY_COLORS=1"}
+	}
+	return []string{"TERM=dumb", "NO_COLOR=1"}
+}
@@ -1,9 +1,11 @@
 package xuanwu
 
 import (
-	"fmt"
-	"io"
+	"errors"
 	"log"
+	"sync"
+	"time"
+	"xuanwu/config"
 	xwlog "xuanwu/log"
 
 	"github.com/robfig/cron/v3"
@@ -20,39 +22,35 @@ type TaskInfo struct {
 	WorkDir     string   `json:"workdir"` // 工作目录
 	Exec        string   `json:"exec"`
 	Enable      bool     `json:"enable"` // 是否启用任务
-	Writer      io.WriteCloser
-	Log         *log.Logger
+	Result      *config.TaskResult // 执行结果判定规则
+	Output      *config.OutputLimit // 输出限制
+	Encoding    string // 输出编码
+	Mask        *config.MaskConfig // 输出中需要隐藏的内容
+	Ansi        string // 转义序列的处理方式
+	Color       *bool  // 是否要求输出颜色
 	System      bool
 	Func        func() // 系统任务函数
 	Callback    string
 }
 
-// 定时id和任务的映射表
-var TaskData = map[cron.EntryID]TaskInfo{}
+// 定时id和任务的映射表,配置监听和接口会同时修改,通过 taskDataLock 访问
+var (
+	taskData     = map[cron.EntryID]TaskInfo{}
+	taskDataLock sync.RWMutex
+)
+
 
-// 定时任务
+// 定时任务,启动调度后立即返回,关闭时调用 StopScheduler
 func CronInit(cfg gjson.Result) {
 	tasks := cfg.Get("task")
-	C = cron.New(
-		cron.WithParser(cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)))
+	C = cron.New(cron.WithParser(config.CronParser))
 
 	tasks.ForEach(func(key, value gjson.Result) bool { //添加用户自定义任务
 		enable := value.Get("enable").Bool()
 		if !enable { //启动时候是否执行
 			return true
 		}
-		TaskData := TaskInfo{
-			Name: value.Get("name").String(),
-			Times: func() []string {
-				var times []string
-				for _, t := range value.Get("times").Array() {
-					times = append(times, t.String())
-				}
-				return times
-			}(),
-			WorkDir: value.Get("workdir").String(),
-			Exec: value.Get("exec").String(),
-		}
+		TaskData := TaskFromConfig(value)
 		AddRunFunc(TaskData)
 		return true
 	})
@@ -66,8 +64,11 @@ func CronInit(cfg gjson.Result) {
 	}
 
 	C.Start()
-	defer C.Stop()
-	select {}
+}
+
+// InitIdleScheduler 创建不启动的调度器,命令行在服务未运行时修改任务使用
+func InitIdleScheduler() {
+	C = cron.New(cron.WithParser(config.CronParser))
 }
 
 /* 根据任务类型,添加任务
@@ -77,16 +78,12 @@ func CronInit(cfg gjson.Result) {
 * workDir 工作目录
  */
 func AddRunFunc(TaskInfo TaskInfo) {
-	logname := fmt.Sprintf("%s.log", TaskInfo.Name)
-	if TaskInfo.System {
-		logname = ""
-	}
-	
-	// 初始化日志
-	log, writer := xwlog.LogInitWithConfig(logname, &xwlog.LogConfig{TaskLogFormat: true})
-	TaskInfo.Writer = writer
-	TaskInfo.Log = log
-	
+	taskDataLock.Lock()
+	defer taskDataLock.Unlock()
+	addRunFunc(TaskInfo)
+}
+
+func addRunFunc(TaskInfo TaskInfo) {
 	// 遍历时间数组,为每个时间创建定时任务
 	for _, timeStr := range TaskInfo.Times {
 		// 添加定时任务
@@ -99,9 +96,8 @@ func AddRunFunc(TaskInfo TaskInfo) {
 		} else {
 			// 普通任务执行命令
 			id, err = C.AddFunc(timeStr, func() {
-				if err := ExecTask(TaskInfo.Exec, TaskInfo.WorkDir, log); err != nil {
-					log.Printf("任务执行失败: %v\n", err)
-				}
+				xwlog.Debugf("触发任务[%s]", TaskInfo.Name)
+				RunTask(TaskInfo, nil)
 			})
 		}
 		
@@ -111,7 +107,64 @@ func AddRunFunc(TaskInfo TaskInfo) {
 		}
 		
 		// 保存到任务映射表
-		TaskData[id] = TaskInfo
+		taskData[id] = TaskInfo
+	}
+}
+
+// RemoveTask 从调度中移除指定名称的任务,正在执行的不受影响
+func RemoveTask(name string) bool {
+	taskDataLock.Lock()
+	defer taskDataLock.Unlock()
+	return removeTask(name)
+}
+
+func removeTask(name string) bool {
+	removed := false
+	for id, taskInfo := range taskData {
+		if taskInfo.System || taskInfo.Name != name {
+			continue
+		}
+		C.Remove(id)
+		delete(taskData, id)
+		removed = true
+	}
+	return removed
+}
+
+// ReplaceTask 移除同名任务后重新添加,两步在同一次加锁中完成,避免并发修改时重复调度
+func ReplaceTask(task TaskInfo) {
+	taskDataLock.Lock()
+	defer taskDataLock.Unlock()
+	removeTask(task.Name)
+	addRunFunc(task)
+}
+
+// TaskSnapshot 返回定时id和任务映射表的副本,遍历时不需要持有锁
+func TaskSnapshot() map[cron.EntryID]TaskInfo {
+	taskDataLock.RLock()
+	defer taskDataLock.RUnlock()
+	snapshot := make(map[cron.EntryID]TaskInfo, len(taskData))
+	for id, task := range taskData {
+		snapshot[id] = task
+	}
+	return snapshot
+}
+
+// SchedulerAlive 检查调度循环是否仍在响应,循环阻塞时获取任务列表不会返回
+func SchedulerAlive(timeout time.Duration) error {
+	if C == nil {
+		return errors.New("调度尚未启动")
+	}
+	done := make(chan struct{})
+	go func() {
+		C.Entries()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("调度循环无响应")
 	}
 }
 
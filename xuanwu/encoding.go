@@ -0,0 +1,190 @@
+package xuanwu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+	"xuanwu/config"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	// 未限制行长度时单行最多保留的字节数,避免没有换行的输出占满内存
+	hardLineBytes = 8 * 1024 * 1024
+	// 读取输出的缓冲区大小,更长的行分多次读取
+	outputBufferSize = 64 * 1024
+	// 自动检测编码时判断为UTF-16所需的最少字节数
+	detectMinBytes = 4
+)
+
+// taskEncoding 任务输出编码,未配置时Windows为GBK,其他系统为UTF-8
+func taskEncoding(task TaskInfo) string {
+	if task.Encoding != "" {
+		return strings.ToLower(task.Encoding)
+	}
+	if config.IsWindows {
+		return config.EncodingGBK
+	}
+	return config.EncodingUTF8
+}
+
+// encodingByName 编码名称对应的解码器,UTF-8和自动检测返回nil
+func encodingByName(name string) encoding.Encoding {
+	switch name {
+	case config.EncodingGBK:
+		return simplifiedchinese.GBK
+	case config.EncodingGB18030:
+		return simplifiedchinese.GB18030
+	case config.EncodingBig5:
+		return traditionalchinese.Big5
+	case config.EncodingUTF16:
+		// 没有BOM时按小端处理,Windows程序输出的UTF-16通常为小端
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	case config.EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case config.EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+	return nil
+}
+
+// outputReader 按行读取任务输出,转换为UTF-8,超长的行截断,二进制内容替换为说明
+type outputReader struct {
+	r       *bufio.Reader
+	maxLine int                 // 单行最多保留的字节数
+	perLine func([]byte) []byte // 按行转换编码,用于自动检测时不是UTF-8的行
+}
+
+// newOutputReader 创建按编码读取输出的reader,maxLine为0时使用 hardLineBytes
+func newOutputReader(r io.Reader, name string, maxLine int) *outputReader {
+	if maxLine <= 0 || maxLine > hardLineBytes {
+		maxLine = hardLineBytes
+	}
+	o := &outputReader{maxLine: maxLine}
+	if name == config.EncodingAuto {
+		br := bufio.NewReaderSize(r, outputBufferSize)
+		if enc := detectEncoding(br); enc != nil {
+			r = transform.NewReader(br, enc.NewDecoder())
+		} else {
+			r = br
+			o.perLine = decodeAutoLine
+		}
+	} else if enc := encodingByName(name); enc != nil {
+		r = transform.NewReader(r, enc.NewDecoder())
+	}
+	o.r = bufio.NewReaderSize(r, outputBufferSize)
+	return o
+}
+
+// detectEncoding 根据最先收到的输出判断是否为带BOM的编码或UTF-16,其他情况返回nil按行检测。
+// 只检查已经收到的内容,不等待更多输出
+func detectEncoding(br *bufio.Reader) encoding.Encoding {
+	if _, err := br.Peek(1); err != nil {
+		return nil
+	}
+	head, _ := br.Peek(br.Buffered())
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return unicode.UTF8BOM
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}), bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	}
+	if len(head) < detectMinBytes {
+		return nil
+	}
+	// 没有BOM的UTF-16中ASCII字符的高位字节为0,统计奇偶位置上0的比例
+	var even, odd int
+	for i, b := range head {
+		if b == 0 {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	half := len(head) / 2
+	switch {
+	case odd*10 >= half*7 && even*10 < half:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case even*10 >= half*7 && odd*10 < half:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+	return nil
+}
+
+// decodeAutoLine 自动检测时按行转换,不是有效的UTF-8时按GB18030(兼容GBK)转换
+func decodeAutoLine(line []byte) []byte {
+	if utf8.Valid(line) {
+		return line
+	}
+	decoded, _, err := transform.Bytes(simplifiedchinese.GB18030.NewDecoder(), line)
+	if err != nil {
+		return line
+	}
+	return decoded
+}
+
+// ReadLine 读取一行,不含换行符。超过长度限制的部分读取后丢弃,在内容后说明省略的字节数
+func (o *outputReader) ReadLine() (string, error) {
+	var line []byte
+	omitted := 0
+	for {
+		// ReadSlice 在超长行时返回缓冲区大小的片段,不会把整行读入内存
+		chunk, err := o.r.ReadSlice('\n')
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if room := o.maxLine - len(line); len(chunk) > room {
+			omitted += len(chunk) - room
+			chunk = chunk[:room]
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && len(line) == 0 && omitted == 0 {
+			return "", err
+		}
+		// 有内容时先返回这一行,下次读取再返回错误
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		text := o.text(line)
+		if omitted > 0 {
+			text = fmt.Sprintf("%s ...(该行过长,省略%d字节)", text, omitted)
+		}
+		return text, nil
+	}
+}
+
+// text 转换编码,二进制内容不写入日志,只记录长度
+func (o *outputReader) text(line []byte) string {
+	if o.perLine != nil {
+		line = o.perLine(line)
+	}
+	if isBinary(line) {
+		return fmt.Sprintf("[二进制输出,%d字节]", len(line))
+	}
+	return strings.ToValidUTF8(string(line), "�")
+}
+
+// isBinary 包含NUL或较多控制字符时视为二进制内容,编码不正确的文本仍按文本记录
+func isBinary(line []byte) bool {
+	if bytes.IndexByte(line, 0) >= 0 {
+		return true
+	}
+	control := 0
+	for _, b := range line {
+		if b < 0x20 && b != '\t' && b != '\r' && b != 0x1b || b == 0x7f {
+			control++
+		}
+	}
+	return control > 0 && control*10 > len(line)
+}
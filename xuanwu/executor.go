@@ -1,22 +1,73 @@
 package xuanwu
 
 import (
-	"bufio"
-	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"xuanwu/config"
 	"xuanwu/lib/pathutil"
 	xwlog "xuanwu/log"
+)
+
+// 进程退出后等待读取剩余输出的时间,后台进程继承了输出管道时不会一直等待
+const outputDrainDelay = 2 * time.Second
 
-	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/transform"
+// 正在执行的任务进程和执行记录
+var (
+	runningCmds  = map[*exec.Cmd]string{} // 进程和任务名称
+	runningLock  sync.Mutex
+	runningWg    sync.WaitGroup // 正在进行的执行,执行日志完成后才结束
+	shuttingDown bool
 )
 
+// errShuttingDown 系统关闭中不再执行新任务
+var errShuttingDown = errors.New("系统正在关闭,任务未执行")
+
+// beginRun 登记一次执行,系统关闭中返回false。执行日志完成后需要调用 endRun,
+// 关闭时 WaitRunning 等待到执行记录保存后才返回
+func beginRun() bool {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if shuttingDown {
+		return false
+	}
+	runningWg.Add(1)
+	return true
+}
+
+// endRun 执行记录保存后取消登记
+func endRun() {
+	runningWg.Done()
+}
+
+// startCmd 启动进程并登记,两步在同一次加锁中完成,登记的进程都已启动。系统关闭中不再启动
+func startCmd(cmd *exec.Cmd, name string) error {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if shuttingDown {
+		return errShuttingDown
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	runningCmds[cmd] = name
+	return nil
+}
+
+// untrackCmd 进程结束后取消登记,之后关闭时不再需要结束该进程
+func untrackCmd(cmd *exec.Cmd) {
+	runningLock.Lock()
+	delete(runningCmds, cmd)
+	runningLock.Unlock()
+}
+
 // 处理工作目录路径
 func HandleWorkDir(workDir string) string {
 	// 如果工作目录为空,则返回data目录
@@ -40,45 +91,19 @@ func HandleWorkDir(workDir string) string {
 	return pathutil.GetDataPath(workDir)
 }
 
-// 将GBK编码的文本转换为UTF8
-func convertGBKToUTF8(text string) string {
-	// 如果不是Windows系统,直接返回
-	if !config.IsWindows {
-		return text
-	}
-
-	// 创建GBK到UTF8的转换器
-	reader := transform.NewReader(strings.NewReader(text), simplifiedchinese.GBK.NewDecoder())
-	var buf bytes.Buffer
-	_, err := io.Copy(&buf, reader)
-	if err != nil {
-		return text // 如果转换失败,返回原文
-	}
-	return buf.String()
-}
-
-// 创建一个支持编码转换的Scanner
-func newEncodingScanner(reader io.Reader) *bufio.Scanner {
-	if config.IsWindows {
-		// Windows环境下,创建一个GBK到UTF8的转换器
-		utf8Reader := transform.NewReader(reader, simplifiedchinese.GBK.NewDecoder())
-		return bufio.NewScanner(utf8Reader)
-	}
-	return bufio.NewScanner(reader)
+// OutputWriter 接收任务输出的每一行及其输出流
+type OutputWriter interface {
+	WriteLine(stream xwlog.Stream, text string) error
 }
 
-// 执行任务命令
-func ExecTask(command string, workDir string, logger *log.Logger) error {
+// 执行任务命令,按任务的输出编码和单行长度限制逐行交给out
+func ExecTask(task TaskInfo, out OutputWriter) error {
 	// 记录开始时间
 	startTime := time.Now()
-	
-	// 如果logger实现了我们的接口，设置开始时间
-	if tw, ok := logger.Writer().(xwlog.TaskLogWriter); ok {
-		tw.SetStartTime(startTime)
-	}
 
 	// 处理工作目录
-	workDir = HandleWorkDir(workDir)
+	command := task.Exec
+	workDir := HandleWorkDir(task.WorkDir)
 	
 	// 创建命令
 	var cmd *exec.Cmd
@@ -92,54 +117,201 @@ func ExecTask(command string, workDir string, logger *log.Logger) error {
 	if workDir != "" {
 		cmd.Dir = workDir
 	}
+	// 按配置要求或禁止输出颜色,后面的同名变量覆盖继承的环境变量
+	if task.Color != nil {
+		cmd.Env = append(os.Environ(), colorEnv(*task.Color)...)
+	}
+	setProcessGroup(cmd)
 	
-	// 获取输出管道
-	stdout, err := cmd.StdoutPipe()
+	// 创建输出管道,StdoutPipe 的读取端在Wait时立即关闭,这里改为进程退出后由本函数关闭
+	stdout, stdoutW, err := os.Pipe()
 	if err != nil {
 		return err
 	}
-	stderr, err := cmd.StderrPipe()
+	stderr, stderrW, err := os.Pipe()
 	if err != nil {
+		stdout.Close()
+		stdoutW.Close()
 		return err
 	}
+	closePipes := func() {
+		stdout.Close()
+		stderr.Close()
+	}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
 	
 	// 使用WaitGroup等待所有输出读取完成
 	var wg sync.WaitGroup
-	
-	// 开始执行命令
-	if err := cmd.Start(); err != nil {
+
+	// 开始执行命令,子进程已继承写入端,关闭本进程的副本,所有子进程退出后读取端才会收到EOF
+	err = startCmd(cmd, task.Name)
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		closePipes()
 		return err
 	}
 	
-	// 异步读取标准输出
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		scanner := newEncodingScanner(stdout)
-		for scanner.Scan() {
-			logger.Println(scanner.Text())
-		}
-	}()
-	
-	// 异步读取标准错误
-	wg.Add(1)
-	go func() {
+	// 异步读取标准输出和标准错误,分别记录输出流
+	// 输出超过限制需要结束进程时继续读取,避免进程因管道写满而阻塞
+	var killOnce sync.Once
+	var stopped atomic.Bool // 已停止读取,之后的输出不再记录
+	encoding, maxLine := taskEncoding(task), taskOutputLimit(task).MaxLineBytes
+	readOutput := func(reader io.Reader, stream xwlog.Stream) {
 		defer wg.Done()
-		scanner := newEncodingScanner(stderr)
-		for scanner.Scan() {
-			logger.Println(scanner.Text())
+		lines := newOutputReader(reader, encoding, maxLine)
+		for {
+			text, err := lines.ReadLine()
+			if stopped.Load() {
+				return
+			}
+			if err != nil {
+				// 读取出错时丢弃剩余输出,避免进程因管道写满而阻塞
+				if err != io.EOF {
+					log.Printf("读取任务输出失败: %v", err)
+					io.Copy(io.Discard, reader)
+				}
+				return
+			}
+			if err := out.WriteLine(stream, text); err == ErrOutputLimit {
+				killOnce.Do(func() {
+					if err := killProcessGroup(cmd); err != nil {
+						log.Printf("结束任务进程失败[pid=%d]: %v", cmd.Process.Pid, err)
+					}
+				})
+			}
 		}
-	}()
+	}
+	wg.Add(2)
+	go readOutput(stdout, xwlog.StreamStdout)
+	go readOutput(stderr, xwlog.StreamStderr)
 	
 	// 等待命令执行完成
 	err = cmd.Wait()
+	untrackCmd(cmd)
 	
-	// 等待所有输出读取完成
-	wg.Wait()
+	// 等待所有输出读取完成,后台进程仍持有输出管道时读取不会结束,超过等待时间后关闭读取端
+	if !waitTimeout(&wg, outputDrainDelay) {
+		stopped.Store(true)
+		// Windows上关闭会等待正在进行的读取,不在这里阻塞
+		go stdout.Close()
+		go stderr.Close()
+		waitTimeout(&wg, outputDrainDelay)
+		out.WriteLine(xwlog.StreamSystem, "任务进程已退出,后台进程仍持有输出管道,不再读取之后的输出")
+	} else {
+		closePipes()
+	}
 
 	// 计算并输出执行用时
 	duration := time.Since(startTime)
-	logger.Printf("任务完成，用时：%v\n", duration)
+	out.WriteLine(xwlog.StreamSystem, fmt.Sprintf("任务完成，用时：%v", duration))
 	
 	return err
 }
+
+// waitTimeout 等待wg完成,超时返回false
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// runOutput 将任务输出按配置处理转义序列并隐藏敏感内容后按结果判定规则检查,按输出限制写入执行日志,
+// extra不为空时同时写入纯文本
+type runOutput struct {
+	run      *xwlog.RunLog
+	ansi     string
+	mask     *masker
+	classify *resultClassifier
+	limiter  *outputLimiter
+	extra    io.Writer
+	mu       sync.Mutex
+}
+
+func newRunOutput(run *xwlog.RunLog, task TaskInfo, extra io.Writer) *runOutput {
+	o := &runOutput{
+		run:      run,
+		ansi:     taskAnsiMode(task),
+		mask:     newMasker(task.Name, task.Mask),
+		classify: newResultClassifier(task.Name, task.Result),
+		extra:    extra,
+	}
+	o.limiter = &outputLimiter{limit: taskOutputLimit(task), emit: o.emit}
+	return o
+}
+
+func (o *runOutput) WriteLine(stream xwlog.Stream, text string) error {
+	// 先处理转义序列,保留的颜色代码在隐藏时跳过,不会把敏感内容分隔开
+	text = o.mask.mask(convertANSI(o.ansi, text))
+	// 系统信息不受输出限制,写入前先输出暂存的内容以保持顺序
+	if stream == xwlog.StreamSystem {
+		o.limiter.flush()
+		return o.emit(time.Now(), stream, text)
+	}
+	o.classify.matchLine(xwlog.StripANSI(text))
+	return o.limiter.line(stream, text)
+}
+
+// emit 写入执行日志和extra
+func (o *runOutput) emit(t time.Time, stream xwlog.Stream, text string) error {
+	if o.extra != nil {
+		o.mu.Lock()
+		io.WriteString(o.extra, text+"\n")
+		o.mu.Unlock()
+	}
+	return o.run.WriteLineAt(t, stream, text)
+}
+
+// RunTask 执行任务并将输出写入本次执行的日志,extra不为空时同时写入,返回执行ID和按判定规则分类的结果
+func RunTask(task TaskInfo, extra io.Writer) (string, xwlog.RunResult) {
+	// 从启动进程到保存执行记录都登记为正在执行,关闭时等待记录保存后再退出
+	if !beginRun() {
+		return "", xwlog.RunResult{Status: xwlog.RunFailure, ExitCode: -1, Error: errShuttingDown.Error(), Reason: "系统正在关闭"}
+	}
+	defer endRun()
+
+	run, err := xwlog.StartRun(task.Name)
+	if err != nil {
+		log.Printf("创建任务日志失败[%s]: %v", task.Name, err)
+		return "", xwlog.RunResult{Status: xwlog.RunFailure, ExitCode: -1, Error: err.Error(), Reason: "创建任务日志失败"}
+	}
+
+	out := newRunOutput(run, task, extra)
+	execErr := ExecTask(task, out)
+	out.limiter.flush()
+	result := out.classify.result(execErr)
+	if result.Truncated = out.limiter.truncated(); result.Truncated && out.limiter.limit.OnLimit == config.OutputLimitKill {
+		result.Status = xwlog.RunFailure
+		result.Reason = "输出超过限制,已结束任务进程"
+	}
+	result.Error = out.mask.mask(result.Error)
+	switch result.Status {
+	case xwlog.RunFailure:
+		run.WriteLine(xwlog.StreamSystem, "任务执行失败: "+ResultMessage(result))
+	case xwlog.RunWarning:
+		run.WriteLine(xwlog.StreamSystem, "任务执行警告: "+ResultMessage(result))
+	}
+	if err := run.Finish(result); err != nil {
+		log.Printf("保存任务日志失败[%s]: %v", task.Name, err)
+	}
+	return run.ID(), result
+}
+
+// ResultMessage 执行结果的说明,包含出错原因和判定依据
+func ResultMessage(result xwlog.RunResult) string {
+	if result.Error != "" && result.Reason != "" {
+		return fmt.Sprintf("%s (%s)", result.Error, result.Reason)
+	}
+	if result.Error != "" {
+		return result.Error
+	}
+	return result.Reason
+}
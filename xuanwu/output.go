@@ -0,0 +1,184 @@
+package xuanwu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"xuanwu/config"
+	xwlog "xuanwu/log"
+
+	"github.com/tidwall/gjson"
+)
+
+// 默认的输出限制
+const (
+	defaultOutputKB     = 10 * 1024
+	defaultLineBytes    = 64 * 1024
+	defaultOutputAction = config.OutputLimitContinue
+)
+
+// ErrOutputLimit 输出超过限制且配置为结束进程时由 OutputWriter 返回,ExecTask 收到后结束任务进程
+var ErrOutputLimit = errors.New("输出超过限制")
+
+// OutputLimit 生效的输出限制,0表示不限制
+type OutputLimit struct {
+	MaxBytes     int64  `json:"max_bytes"`      // 每次执行保留的输出大小
+	MaxLineBytes int    `json:"max_line_bytes"` // 单行的最大长度
+	OnLimit      string `json:"on_limit"`       // 超过大小限制时的处理方式 continue/kill
+}
+
+var (
+	outputLimit     = defaultOutputLimit()
+	outputLimitLock sync.Mutex
+)
+
+// ParseOutputLimit 从配置的 task_log.output 中解析所有任务默认的输出限制
+func ParseOutputLimit(cfg gjson.Result) OutputLimit {
+	return defaultOutputLimit().merge(config.ParseOutputLimit(cfg.Get("task_log.output")))
+}
+
+// SetOutputLimit 修改默认的输出限制,下次执行任务时生效
+func SetOutputLimit(limit OutputLimit) {
+	outputLimitLock.Lock()
+	outputLimit = limit
+	outputLimitLock.Unlock()
+}
+
+// GetOutputLimit 当前默认的输出限制
+func GetOutputLimit() OutputLimit {
+	outputLimitLock.Lock()
+	defer outputLimitLock.Unlock()
+	return outputLimit
+}
+
+func defaultOutputLimit() OutputLimit {
+	return OutputLimit{MaxBytes: defaultOutputKB * 1024, MaxLineBytes: defaultLineBytes, OnLimit: defaultOutputAction}
+}
+
+// merge 用配置中指定的项覆盖当前限制
+func (l OutputLimit) merge(cfg *config.OutputLimit) OutputLimit {
+	if cfg == nil {
+		return l
+	}
+	if cfg.MaxKB != nil {
+		l.MaxBytes = int64(*cfg.MaxKB) * 1024
+	}
+	if cfg.MaxLineBytes != nil {
+		l.MaxLineBytes = *cfg.MaxLineBytes
+	}
+	if cfg.OnLimit != "" {
+		l.OnLimit = cfg.OnLimit
+	}
+	return l
+}
+
+// taskOutputLimit 任务生效的输出限制,任务未配置的项使用默认值
+func taskOutputLimit(task TaskInfo) OutputLimit {
+	return GetOutputLimit().merge(task.Output)
+}
+
+// bufferedLine 暂存的一行输出
+type bufferedLine struct {
+	time   time.Time
+	stream xwlog.Stream
+	text   string
+}
+
+// outputLimiter 按输出限制决定每行输出的去向。
+// 未超过限制时直接输出;超过后 continue 方式将后续输出暂存,只保留最后一部分,结束时在省略标记后写入;
+// kill 方式丢弃后续输出并要求结束进程
+type outputLimiter struct {
+	limit OutputLimit
+	emit  func(t time.Time, stream xwlog.Stream, text string) error
+
+	mu           sync.Mutex
+	written      int64 // 已直接输出的字节数
+	limited      bool  // 已超过大小限制
+	tail         []bufferedLine
+	tailBytes    int64
+	omittedLines int
+	omittedBytes int64
+}
+
+// headBytes 直接输出的字节数上限,continue 方式保留一半给结尾的输出
+func (l *outputLimiter) headBytes() int64 {
+	if l.limit.OnLimit == config.OutputLimitKill {
+		return l.limit.MaxBytes
+	}
+	return l.limit.MaxBytes / 2
+}
+
+// line 处理标准输出或标准错误的一行,单行长度已在读取时限制,需要结束进程时返回 ErrOutputLimit
+func (l *outputLimiter) line(stream xwlog.Stream, text string) error {
+	size := int64(len(text)) + 1
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit.MaxBytes <= 0 || !l.limited && l.written+size <= l.headBytes() {
+		l.written += size
+		return l.emit(time.Now(), stream, text)
+	}
+
+	if l.limit.OnLimit == config.OutputLimitKill {
+		l.omittedLines++
+		l.omittedBytes += size
+		if l.limited {
+			return nil
+		}
+		l.limited = true
+		l.emit(time.Now(), xwlog.StreamSystem, fmt.Sprintf("输出超过%s限制,结束任务进程", formatBytes(l.limit.MaxBytes)))
+		return ErrOutputLimit
+	}
+
+	// 只保留最后的输出,超出部分从最早的开始丢弃
+	l.limited = true
+	l.tail = append(l.tail, bufferedLine{time: time.Now(), stream: stream, text: text})
+	l.tailBytes += size
+	for len(l.tail) > 0 && l.tailBytes > l.limit.MaxBytes-l.headBytes() {
+		dropped := int64(len(l.tail[0].text)) + 1
+		l.tail = l.tail[1:]
+		l.tailBytes -= dropped
+		l.omittedLines++
+		l.omittedBytes += dropped
+	}
+	return nil
+}
+
+// flush 写入省略标记和暂存的结尾输出,在进程结束后或写入系统信息前调用
+func (l *outputLimiter) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.omittedLines > 0 {
+		msg := fmt.Sprintf("...输出超过%s限制,省略%d行(%s)...", formatBytes(l.limit.MaxBytes), l.omittedLines, formatBytes(l.omittedBytes))
+		if err := l.emit(time.Now(), xwlog.StreamSystem, msg); err != nil {
+			return err
+		}
+		l.omittedLines, l.omittedBytes = 0, 0
+	}
+	for _, line := range l.tail {
+		if err := l.emit(line.time, line.stream, line.text); err != nil {
+			return err
+		}
+	}
+	l.tail, l.tailBytes = nil, 0
+	return nil
+}
+
+// truncated 是否有输出因超过限制被省略
+func (l *outputLimiter) truncated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limited
+}
+
+// formatBytes 将字节数转换为便于阅读的形式
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/1024/1024)
+	case n >= 1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d字节", n)
+}
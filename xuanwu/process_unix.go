@@ -0,0 +1,21 @@
+//go:build !windows
+
+package xuanwu
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 让任务进程使用独立的进程组,便于关闭时连同子进程一起结束
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup 结束任务进程及其子进程
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
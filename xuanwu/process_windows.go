@@ -0,0 +1,19 @@
+//go:build windows
+
+package xuanwu
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup Windows下不需要设置,结束时通过taskkill结束进程树
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup 结束任务进程及其子进程
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
@@ -0,0 +1,161 @@
+package xuanwu
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/config"
+	xwlog "xuanwu/log"
+
+	"github.com/tidwall/gjson"
+)
+
+// ReloadResult 重新加载配置后调度任务的变化
+type ReloadResult struct {
+	Added   []string `json:"added"`   // 新增的任务
+	Removed []string `json:"removed"` // 移除的任务
+	Updated []string `json:"updated"` // 重新调度的任务
+}
+
+var (
+	reloadLock  sync.Mutex
+	reloadHooks []func(gjson.Result)
+)
+
+// OnConfigReload 注册配置重新加载后的回调,用于刷新各模块缓存的配置
+func OnConfigReload(fn func(gjson.Result)) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// TaskFromConfig 将配置中的任务转换为TaskInfo
+func TaskFromConfig(value gjson.Result) TaskInfo {
+	var times []string
+	for _, t := range value.Get("times").Array() {
+		times = append(times, t.String())
+	}
+	return TaskInfo{
+		Name:     value.Get("name").String(),
+		Times:    times,
+		WorkDir:  value.Get("workdir").String(),
+		Exec:     value.Get("exec").String(),
+		Enable:   value.Get("enable").Bool(),
+		Result:   config.ParseTaskResult(value),
+		Output:   config.ParseOutputLimit(value.Get("output")),
+		Encoding: value.Get("encoding").String(),
+		Mask:     config.ParseMaskConfig(value.Get("mask")),
+		Ansi:     value.Get("ansi").String(),
+		Color:    config.ParseTaskColor(value),
+	}
+}
+
+// SyncTasks 将调度中的用户任务与配置同步,只处理有变化的任务
+func SyncTasks(cfg gjson.Result) *ReloadResult {
+	result := &ReloadResult{}
+
+	// 配置中启用的任务
+	desired := map[string]TaskInfo{}
+	var order []string
+	for _, value := range cfg.Get("task").Array() {
+		task := TaskFromConfig(value)
+		if !task.Enable {
+			continue
+		}
+		desired[task.Name] = task
+		order = append(order, task.Name)
+	}
+
+	// 调度中正在运行的任务
+	live := map[string]TaskInfo{}
+	for _, task := range TaskSnapshot() {
+		if !task.System {
+			live[task.Name] = task
+		}
+	}
+
+	for name := range live {
+		if _, ok := desired[name]; !ok {
+			RemoveTask(name)
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for _, name := range order {
+		want := desired[name]
+		cur, ok := live[name]
+		if ok && reflect.DeepEqual(cur.Times, want.Times) && cur.WorkDir == want.WorkDir && cur.Exec == want.Exec && cur.Encoding == want.Encoding && cur.Ansi == want.Ansi &&
+			reflect.DeepEqual(cur.Color, want.Color) &&
+			reflect.DeepEqual(cur.Result, want.Result) && reflect.DeepEqual(cur.Output, want.Output) && reflect.DeepEqual(cur.Mask, want.Mask) {
+			continue
+		}
+		if ok {
+			result.Updated = append(result.Updated, name)
+		} else {
+			result.Added = append(result.Added, name)
+		}
+		// 接口可能同时添加了该任务,替换而不是直接添加
+		ReplaceTask(want)
+	}
+	return result
+}
+
+// Reload 重新读取config.json,校验通过后同步调度任务并刷新缓存配置
+func Reload() (*ReloadResult, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	if C == nil {
+		return nil, fmt.Errorf("调度尚未启动")
+	}
+	// 手动恢复的旧版本配置先升级
+	if _, err := config.Migrate(); err != nil {
+		return nil, err
+	}
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	if err := config.ValidateConfig([]byte(cfg.Raw)); err != nil {
+		return nil, err
+	}
+	if fields := config.UnknownFields([]byte(cfg.Raw)); len(fields) > 0 {
+		xwlog.Warnf("配置中存在未知字段,已忽略: %s", strings.Join(fields, ", "))
+	}
+
+	result := SyncTasks(cfg)
+	if days := cfg.Get("log_clean_days").Int(); days > 0 {
+		UpdateLogCleanDays(int(days))
+	}
+	for _, fn := range reloadHooks {
+		fn(cfg)
+	}
+	log.Printf("配置已重新加载: 新增%v 移除%v 更新%v", result.Added, result.Removed, result.Updated)
+	return result, nil
+}
+
+// WatchConfig 定时检查config.json的修改时间,有变化时自动重新加载
+func WatchConfig(interval time.Duration) {
+	configPath := config.ConfigPath()
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(configPath); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+	for range time.Tick(interval) {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+			continue
+		}
+		lastMod, lastSize = info.ModTime(), info.Size()
+		if _, err := Reload(); err != nil {
+			log.Printf("配置文件变化,重新加载失败,继续使用当前配置: %v", err)
+		}
+	}
+}
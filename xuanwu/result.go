@@ -0,0 +1,141 @@
+package xuanwu
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"xuanwu/config"
+	xwlog "xuanwu/log"
+)
+
+// 判定原因中记录的输出最多保留的字节数
+const reasonMaxLen = 200
+
+// resultPattern 输出判定规则中的一条正则表达式
+type resultPattern struct {
+	re   *regexp.Regexp
+	name string // 规则在配置中的位置,用于记录判定原因
+}
+
+// resultClassifier 按任务的结果判定规则对一次执行分类,输出的每一行都需要经过 matchLine
+type resultClassifier struct {
+	successCodes map[int]bool
+	warningCodes map[int]bool
+	failure      []resultPattern
+	warning      []resultPattern
+
+	mu            sync.Mutex
+	failureReason string // 第一条匹配失败规则的输出
+	warningReason string // 第一条匹配警告规则的输出
+}
+
+// newResultClassifier 编译结果判定规则,rules为nil时只有退出码0为成功
+func newResultClassifier(name string, rules *config.TaskResult) *resultClassifier {
+	c := &resultClassifier{successCodes: map[int]bool{}, warningCodes: map[int]bool{}}
+	if rules == nil || len(rules.SuccessCodes) == 0 {
+		c.successCodes[0] = true
+	}
+	if rules == nil {
+		return c
+	}
+	for _, code := range rules.SuccessCodes {
+		c.successCodes[code] = true
+	}
+	for _, code := range rules.WarningCodes {
+		c.warningCodes[code] = true
+	}
+	compile := func(field string, patterns []string) []resultPattern {
+		var list []resultPattern
+		for i, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				// 配置写入时已校验,这里只可能是手动修改后未重新加载
+				xwlog.Warnf("任务[%s]的%s[%d]正则表达式错误: %v", name, field, i, err)
+				continue
+			}
+			list = append(list, resultPattern{re: re, name: fmt.Sprintf("%s[%d]", field, i)})
+		}
+		return list
+	}
+	c.failure = compile("failure_patterns", rules.FailurePatterns)
+	c.warning = compile("warning_patterns", rules.WarningPatterns)
+	return c
+}
+
+// matchLine 检查一行标准输出或标准错误是否匹配判定规则,只记录第一次匹配
+func (c *resultClassifier) matchLine(text string) {
+	if len(c.failure) == 0 && len(c.warning) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failureReason == "" {
+		for _, p := range c.failure {
+			if p.re.MatchString(text) {
+				c.failureReason = fmt.Sprintf("输出匹配%s: %s", p.name, reasonText(text))
+				return
+			}
+		}
+	}
+	if c.warningReason == "" {
+		for _, p := range c.warning {
+			if p.re.MatchString(text) {
+				c.warningReason = fmt.Sprintf("输出匹配%s: %s", p.name, reasonText(text))
+				return
+			}
+		}
+	}
+}
+
+// result 根据执行错误、退出码和输出匹配情况得出执行结果
+func (c *resultClassifier) result(execErr error) xwlog.RunResult {
+	res := xwlog.RunResult{Status: xwlog.RunSuccess}
+	var exitErr *exec.ExitError
+	switch {
+	case execErr == nil:
+		res.ExitCode = 0
+	case errors.As(execErr, &exitErr):
+		// 被信号终止时为-1
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		// 未能启动或未执行
+		return xwlog.RunResult{Status: xwlog.RunFailure, ExitCode: -1, Error: execErr.Error(), Reason: "执行出错"}
+	}
+
+	switch {
+	case c.successCodes[res.ExitCode]:
+	case c.warningCodes[res.ExitCode]:
+		res.Status = xwlog.RunWarning
+		res.Reason = fmt.Sprintf("退出码%d", res.ExitCode)
+	default:
+		res.Status = xwlog.RunFailure
+		res.Reason = fmt.Sprintf("退出码%d", res.ExitCode)
+		// 被信号终止时没有退出码,记录终止原因
+		if res.ExitCode == -1 {
+			res.Error = execErr.Error()
+		}
+		return res
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failureReason != "" {
+		res.Status = xwlog.RunFailure
+		res.Reason = c.failureReason
+	} else if res.Status == xwlog.RunSuccess && c.warningReason != "" {
+		res.Status = xwlog.RunWarning
+		res.Reason = c.warningReason
+	}
+	return res
+}
+
+// reasonText 截断过长的输出
+func reasonText(text string) string {
+	if len(text) <= reasonMaxLen {
+		return text
+	}
+	return strings.ToValidUTF8(text[:reasonMaxLen], "") + "..."
+}
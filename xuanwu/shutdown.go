@@ -0,0 +1,51 @@
+package xuanwu
+
+import (
+	"log"
+	"time"
+	xwlog "xuanwu/log"
+)
+
+// StopScheduler 停止定时调度,不再触发新任务,已在执行的任务不受影响
+func StopScheduler() {
+	runningLock.Lock()
+	shuttingDown = true
+	runningLock.Unlock()
+
+	if C != nil {
+		C.Stop()
+	}
+}
+
+// WaitRunning 等待正在执行的任务结束并保存执行记录,超时返回false
+func WaitRunning(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		runningWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// KillRunning 结束所有仍在执行的任务进程组
+func KillRunning() {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	for cmd, name := range runningCmds {
+		if err := killProcessGroup(cmd); err != nil {
+			log.Printf("结束任务进程失败[%s pid=%d]: %v", name, cmd.Process.Pid, err)
+			continue
+		}
+		log.Printf("已结束任务进程[%s pid=%d]", name, cmd.Process.Pid)
+	}
+}
+
+// CloseTaskLogs 关闭仍在执行的任务日志,记录为未完成
+func CloseTaskLogs() {
+	xwlog.CloseRuns("程序退出时执行未结束")
+}
@@ -3,6 +3,7 @@ package xuanwu
 import (
 	"log"
 	"sync"
+	"xuanwu/audit"
 	"xuanwu/config"
 	xwlog "xuanwu/log"
 )
@@ -13,19 +14,6 @@ var (
 	logCleanLock sync.RWMutex
 )
 
-func init() {
-	// 初始化系统日志记录器
-	// logger, _ := xwlog.LogInit("main.log")
-	// systemLogger = logger
-
-	// 初始化日志清理天数
-	if cfg, err := config.ReadConfigFileToJson(); err == nil {
-		if days := cfg.Get("log_clean_days").Int(); days > 0 {
-			logCleanDays = int(days)
-		}
-	}
-}
-
 // 系统任务
 var SystemTask = []TaskInfo{
 	{
@@ -37,6 +25,15 @@ var SystemTask = []TaskInfo{
 		Enable:  true,
 		Func:    cleanLogsTask,
 	},
+	{
+		Name:    "定时清理审计日志",
+		Times:   []string{"@daily"},
+		WorkDir: "",
+		Exec:    "",
+		System:  true,
+		Enable:  true,
+		Func:    cleanAuditTask,
+	},
 	{
 		Name:    "系统测试任务",
 		Times:   []string{"@every 30s"},
@@ -56,21 +53,39 @@ func UpdateLogCleanDays(days int) {
 	logCleanLock.Lock()
 	logCleanDays = days
 	logCleanLock.Unlock()
+
+	// 执行日志未单独配置保留天数时使用日志清理天数
+	if cfg, err := config.ReadConfigFileToJson(); err == nil {
+		xwlog.SetRunRetention(xwlog.ParseRunRetention(config.ApplyOverrides(cfg)))
+	}
 }
 
 // cleanLogsTask 清理过期日志任务
 func cleanLogsTask() {
 	// 记录任务开始
 	log.Printf("定时清理日志")
-	
-	// 使用当前的清理天数
+	if _, err := CleanLogsNow(); err != nil {
+		log.Printf("清理日志失败: %v", err)
+	}
+}
+
+// CleanLogsNow 使用当前的清理天数立即清理日志
+func CleanLogsNow() (*xwlog.CleanReport, error) {
+	return xwlog.Cleanup(GetLogCleanDays())
+}
+
+// GetLogCleanDays 当前的日志清理天数
+func GetLogCleanDays() int {
 	logCleanLock.RLock()
-	days := logCleanDays
-	logCleanLock.RUnlock()
+	defer logCleanLock.RUnlock()
+	return logCleanDays
+}
 
-	if err := xwlog.CleanLogs(days); err != nil {
-		log.Printf("清理日志失败: %v", err)
-		return
+// cleanAuditTask 清理过期审计日志任务
+func cleanAuditTask() {
+	log.Printf("定时清理审计日志")
+	if err := audit.Clean(); err != nil {
+		log.Printf("清理审计日志失败: %v", err)
 	}
 }
 